@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"tic-tac-toe/game"
+	"time"
+)
+
+const (
+	sessionTokenPrefix = "ses_" // session tokens are told apart from JWTs by prefix
+	userSubjectPrefix  = "user:"
+	minPasswordLength  = 8
+	maxPasswordLength  = 72 // bcrypt ignores the rest
+)
+
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// compared with password of unknown user, so response time doesn't tell whether user exists
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("tic-tac-toe dummy password"), bcrypt.DefaultCost)
+
+// parse `{"name":"alice","password":"..."}`, values are copied
+func (ws *webServer) parseCredentials(body []byte) (name, password string, err error) {
+	p := ws.parserPool.Get()
+	defer ws.parserPool.Put(p)
+
+	val, err := p.ParseBytes(body)
+	if err != nil {
+		return "", "", game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err).WithCode(game.CodeInvalidRequest)
+	}
+	return string(val.GetStringBytes("name")), string(val.GetStringBytes("password")), nil
+}
+
+func (ws *webServer) register(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "register")
+
+	name, password, err := ws.parseCredentials(ctx.Request.Body())
+	if err != nil {
+		setProblem(ctx, err)
+		return
+	}
+	if !userNamePattern.MatchString(name) {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "name must be 3-32 letters, digits, dots, dashes or underscores").WithCode(game.CodeInvalidRequest))
+		return
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "password must be "+strconv.Itoa(minPasswordLength)+"-"+strconv.Itoa(maxPasswordLength)+" bytes long").WithCode(game.CodeInvalidRequest))
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Errorln("can't hash password:", err)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusInternalServerError, "can't hash password", err))
+		return
+	}
+
+	u := game.NewUser(name, string(hash))
+	if err = ws.storage.CreateUser(u); err != nil {
+		logger.Errorln("can't create user:", err)
+		setProblem(ctx, err)
+		return
+	}
+	logger.Infoln("user registered:", u.Id)
+
+	ctx.Response.Header.Set(fasthttp.HeaderLocation, ws.baseURL(ctx)+"/api/v2/users/me")
+	ctx.SetContentType(applicationJson)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetBody(marshalUser(u, nil))
+}
+
+// check password and start new session
+func (ws *webServer) login(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "login")
+
+	name, password, err := ws.parseCredentials(ctx.Request.Body())
+	if err != nil {
+		setProblem(ctx, err)
+		return
+	}
+
+	u, err := ws.storage.GetUserByName(name)
+	if err != nil && game.AsGameError(err).Status != fasthttp.StatusNotFound {
+		logger.Errorln("can't get user:", err)
+		setProblem(ctx, err)
+		return
+	}
+	hash := dummyPasswordHash
+	if u != nil {
+		hash = []byte(u.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || u == nil {
+		logger.Infoln("invalid credentials for user name", strconv.Quote(name))
+		setProblem(ctx, game.NewGameError(fasthttp.StatusUnauthorized, "invalid user name or password").WithCode(game.CodeInvalidCredentials))
+		return
+	}
+
+	token, session, err := ws.newSession(u)
+	if err != nil {
+		logger.Errorln("can't create session:", err)
+		setProblem(ctx, err)
+		return
+	}
+	logger.Infoln("user logged in:", u.Id)
+
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("token", a.NewString(token))
+	o.Set("expires_at", timeValue(a, session.Expires))
+	o.Set("user", userValue(a, u, nil))
+
+	ctx.SetContentType(applicationJson)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetBody(o.MarshalTo(nil))
+}
+
+// token is returned to client only, storage keeps its hash
+func (ws *webServer) newSession(u *game.User) (string, *game.Session, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, game.NewGameError(fasthttp.StatusInternalServerError, "can't generate session token", err)
+	}
+	token := sessionTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now().UTC()
+	session := &game.Session{
+		TokenHash: sessionTokenHash(token),
+		UserId:    u.Id,
+		Created:   now,
+		Expires:   now.Add(ws.sessionTTL),
+	}
+	if err := ws.storage.SaveSession(session); err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+func sessionTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// session token from `Authorization: Bearer ses_...` header, empty if there is no such token
+func sessionToken(ctx *fasthttp.RequestCtx) string {
+	auth := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ""
+	}
+	token := strings.TrimSpace(auth[7:])
+	if !strings.HasPrefix(token, sessionTokenPrefix) {
+		return ""
+	}
+	return token
+}
+
+// authenticate user by session token, other bearer tokens are left to JWT authenticator
+func (ws *webServer) sessionIdentity(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	token := sessionToken(ctx)
+	if token == "" {
+		return nil, nil
+	}
+
+	session, err := ws.storage.GetSession(sessionTokenHash(token))
+	if err != nil {
+		if game.AsGameError(err).Status == fasthttp.StatusNotFound {
+			return nil, game.NewGameError(fasthttp.StatusUnauthorized, "invalid session token")
+		}
+		return nil, err
+	}
+	if time.Now().After(session.Expires) {
+		return nil, game.NewGameError(fasthttp.StatusUnauthorized, "session expired")
+	}
+	return &Identity{Subject: userSubjectPrefix + session.UserId, Method: "session"}, nil
+}
+
+func (ws *webServer) logout(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "logout")
+
+	token := sessionToken(ctx)
+	if token == "" {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "request must be authenticated by session token").WithCode(game.CodeInvalidRequest))
+		return
+	}
+	if err := ws.storage.DeleteSession(sessionTokenHash(token)); err != nil {
+		logger.Errorln("can't delete session:", err)
+		setProblem(ctx, err)
+		return
+	}
+	setOkResponse(ctx, nil)
+}
+
+// user of session, other clients have no profile
+func (ws *webServer) currentUser(ctx *fasthttp.RequestCtx) (*game.User, error) {
+	id := identity(ctx)
+	if id == nil || !strings.HasPrefix(id.Subject, userSubjectPrefix) {
+		return nil, game.NewGameError(fasthttp.StatusForbidden, "profile is available for registered users only")
+	}
+	return ws.storage.GetUser(strings.TrimPrefix(id.Subject, userSubjectPrefix))
+}
+
+func (ws *webServer) getProfile(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getProfile")
+
+	u, err := ws.currentUser(ctx)
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	games, err := ws.storage.List()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	setOkResponse(ctx, marshalUser(u, ownGames(ctx, games)))
+}
+
+// games of user, the most recent first
+func (ws *webServer) getUserGames(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getUserGames")
+
+	if _, err := ws.currentUser(ctx); err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	games, err := ws.storage.List()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	games = ownGames(ctx, games)
+	sort.SliceStable(games, func(i, j int) bool {
+		return games[i].Updated().After(games[j].Updated())
+	})
+
+	baseURL := ws.baseURL(ctx)
+	streamGames(ctx, logger, games, func(g *game.Game) []byte {
+		return marshalGameV2(g, baseURL)
+	})
+}
+
+func marshalUser(u *game.User, games []*game.Game) []byte {
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	return userValue(a, u, games).MarshalTo(nil)
+}
+
+// user profile with statistics of games, password hash is never exposed
+func userValue(a *fastjson.Arena, u *game.User, games []*game.Game) *fastjson.Value {
+	o := a.NewObject()
+	o.Set("id", a.NewString(u.Id))
+	o.Set("name", a.NewString(u.Name))
+	o.Set("created_at", timeValue(a, u.Created))
+
+	var running, won, lost, draw int
+	for _, g := range games {
+		switch g.Status() {
+		case game.RUNNING:
+			running++
+		case game.DRAW:
+			draw++
+		case string(g.UserSign()) + "_WON":
+			won++
+		default:
+			lost++
+		}
+	}
+	stats := a.NewObject()
+	stats.Set("total", a.NewNumberInt(len(games)))
+	stats.Set("running", a.NewNumberInt(running))
+	stats.Set("won", a.NewNumberInt(won))
+	stats.Set("lost", a.NewNumberInt(lost))
+	stats.Set("draw", a.NewNumberInt(draw))
+	o.Set("games", stats)
+	return o
+}
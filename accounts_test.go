@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"testing"
+	"tic-tac-toe/game"
+	"time"
+)
+
+func TestLogin_AfterSnapshotRestore(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.accounts = true
+		ws.sessionTTL = time.Hour
+		ws.authenticators = []authenticator{ws.sessionIdentity}
+	})
+	const credentials = `{"name":"alice","password":"correct horse battery"}`
+
+	if resp := doRequest(ws, "POST", "/api/v2/users", credentials); resp.StatusCode() != fasthttp.StatusCreated {
+		t.Fatalf("can't register: %d %s", resp.StatusCode(), resp.Body())
+	}
+	resp := doRequest(ws, "POST", "/api/v2/sessions", credentials)
+	if resp.StatusCode() != fasthttp.StatusCreated {
+		t.Fatalf("can't log in: %d %s", resp.StatusCode(), resp.Body())
+	}
+	token := fastjson.GetString(resp.Body(), "token")
+
+	var buf bytes.Buffer
+	storage := ws.storage.(game.Snapshotter)
+	if err := storage.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.RestoreSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp = doRequest(ws, "POST", "/api/v2/sessions", credentials); resp.StatusCode() != fasthttp.StatusCreated {
+		t.Fatalf("can't log in after restore: %d %s", resp.StatusCode(), resp.Body())
+	}
+	if resp = doRequest(ws, "GET", "/api/v2/users/me", "", "Authorization", "Bearer "+token); resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("session is lost after restore: %d %s", resp.StatusCode(), resp.Body())
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"io"
+	"strconv"
+	"strings"
+	"tic-tac-toe/game"
+	"time"
+)
+
+// stream tar.gz snapshot of game storage
+func (ws *webServer) getSnapshot(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getSnapshot")
+
+	snapshotter, ok := ws.storage.(game.Snapshotter)
+	if !ok {
+		logger.Errorln("storage doesn't support snapshots")
+		setProblem(ctx, game.NewGameError(fasthttp.StatusNotImplemented, "storage doesn't support snapshots"))
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := snapshotter.Snapshot(pw)
+		if err != nil {
+			logger.Errorln("can't write snapshot:", err)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	// files are read before archive is written, so their errors are answered with status
+	r := bufio.NewReader(pr)
+	if _, err := r.Peek(1); err != nil {
+		_ = pr.Close()
+		setProblem(ctx, err)
+		return
+	}
+	ws.audit(newAuditEntry(ctx, auditSnapshot, ""))
+
+	// archive is compressed already
+	ctx.Request.Header.Del(fasthttp.HeaderAcceptEncoding)
+	ctx.SetContentType("application/gzip")
+	ctx.Response.Header.Set("Content-Disposition",
+		`attachment; filename="snapshot-`+time.Now().Format("20060102150405")+`.tar.gz"`)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	// pipe is closed when response is sent or client is gone, so snapshot doesn't block
+	ctx.SetBodyStream(struct {
+		io.Reader
+		io.Closer
+	}{r, pr}, -1)
+}
+
+// report of the last migration backfill
+func (ws *webServer) getMigrationReport(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getMigrationReport")
+
+	migration, ok := ws.storage.(*game.MigrationStorage)
+	if !ok {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusNotFound, "storage migration is not running"))
+		return
+	}
+
+	report := migration.LastReport()
+	if report == nil {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusNotFound, "backfill is not finished yet"))
+		return
+	}
+
+	res, err := json.Marshal(report)
+	if err != nil {
+		logger.Errorln("can't marshal report:", err)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusInternalServerError, "can't marshal report", err))
+		return
+	}
+	setOkResponse(ctx, res)
+}
+
+type storageStats struct {
+	Games     map[string]int `json:"games"` // number of games by status
+	Total     int            `json:"total"`
+	Deleted   int            `json:"deleted"`
+	Owners    int            `json:"owners"` // number of clients having games
+	Anonymous int            `json:"anonymous"`
+}
+
+func (ws *webServer) getStorageStats(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getStorageStats")
+
+	games, err := ws.storage.List()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	deleted, err := ws.storage.ListDeleted()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+
+	stats := &storageStats{
+		Games:   map[string]int{game.RUNNING: 0, game.XWON: 0, game.OWON: 0, game.DRAW: 0},
+		Total:   len(games),
+		Deleted: len(deleted),
+	}
+	owners := map[string]bool{}
+	for _, g := range games {
+		stats.Games[g.Status()]++
+		if g.Owner() == "" {
+			stats.Anonymous++
+		} else {
+			owners[g.Owner()] = true
+		}
+	}
+	stats.Owners = len(owners)
+
+	res, err := json.Marshal(stats)
+	if err != nil {
+		logger.Errorln("can't marshal stats:", err)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusInternalServerError, "can't marshal stats", err))
+		return
+	}
+	setOkResponse(ctx, res)
+}
+
+// games of all clients with their owners, optionally filtered by `owner` and `status` query args
+func (ws *webServer) getAdminGames(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getAdminGames")
+
+	games, err := ws.storage.List()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+
+	args := ctx.QueryArgs()
+	owner, status := string(args.Peek("owner")), string(args.Peek("status"))
+	res := games[:0]
+	for _, g := range games {
+		if (!args.Has("owner") || g.Owner() == owner) && (status == "" || g.Status() == status) {
+			res = append(res, g)
+		}
+	}
+
+	baseURL := ws.baseURL(ctx)
+	streamGames(ctx, logger, res, func(g *game.Game) []byte {
+		return marshalAdminGame(g, baseURL)
+	})
+}
+
+// v2 game with owner and admin which finished it
+func marshalAdminGame(g *game.Game, baseURL string) []byte {
+	res := marshalGameV2(g, baseURL)
+	res = append(res[:len(res)-1], `,"owner":`+strconv.Quote(g.Owner())...)
+	if g.FinishedBy() != "" {
+		res = append(res, `,"finished_by":`+strconv.Quote(g.FinishedBy())...)
+	}
+	return append(res, '}')
+}
+
+// finish running game with `{"status":"DRAW"}` or other result, draw is the default
+func (ws *webServer) finishGame(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "finishGame")
+	gameId := ctx.UserValue("game_id").(string)
+
+	if !ws.storage.IsValidGameId(gameId) {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
+		return
+	}
+
+	status := game.DRAW
+	if body := ctx.Request.Body(); len(body) > 0 {
+		p := ws.parserPool.Get()
+		val, err := p.ParseBytes(body)
+		if err != nil {
+			ws.parserPool.Put(p)
+			setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err).WithCode(game.CodeInvalidRequest))
+			return
+		}
+		if val.Exists("status") {
+			// copy status, it is valid until parser is reused
+			status = string(val.GetStringBytes("status"))
+		}
+		ws.parserPool.Put(p)
+	}
+
+	e := newAuditEntry(ctx, auditFinish, gameId)
+	wasRunning := false
+	g, err := ws.storage.Get(gameId)
+	if err == nil {
+		e.Before = g.MarshalRecord()
+		wasRunning = g.Status() == game.RUNNING
+		err = g.Finish(status, identity(ctx).Subject)
+	}
+	if err == nil {
+		err = ws.storage.Save(g)
+	}
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	if wasRunning {
+		ws.running.add(gameClient(g), -1)
+	}
+	logger.Infof("game %s is finished with %s by %s", gameId, status, identity(ctx).Subject)
+	e.After = g.MarshalRecord()
+	ws.audit(e)
+
+	setOkResponse(ctx, marshalAdminGame(g, ws.baseURL(ctx)))
+}
+
+// assign game to client by `{"owner":"key:alice"}`, e.g. game created before authentication was
+// enabled which belongs to nobody. Empty owner makes game anonymous again
+func (ws *webServer) assignOwner(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "assignOwner")
+	gameId := ctx.UserValue("game_id").(string)
+
+	if !ws.storage.IsValidGameId(gameId) {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
+		return
+	}
+
+	p := ws.parserPool.Get()
+	val, err := p.ParseBytes(ctx.Request.Body())
+	if err != nil {
+		ws.parserPool.Put(p)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err).WithCode(game.CodeInvalidRequest))
+		return
+	}
+	if !val.Exists("owner") {
+		ws.parserPool.Put(p)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "owner is required").WithCode(game.CodeInvalidRequest))
+		return
+	}
+	// copy owner, it is valid until parser is reused
+	owner := strings.TrimSpace(string(val.GetStringBytes("owner")))
+	ws.parserPool.Put(p)
+
+	e := newAuditEntry(ctx, auditAssign, gameId)
+	g, err := ws.storage.Get(gameId)
+	if err == nil {
+		e.Before = g.MarshalRecord()
+		from := gameClient(g)
+		g.SetOwner(owner)
+		if err = ws.storage.Save(g); err == nil && g.Status() == game.RUNNING {
+			// running game is counted for the new owner now
+			ws.running.add(from, -1)
+			ws.running.add(gameClient(g), 1)
+		}
+	}
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	ws.touchList()
+	logger.Infof("game %s is assigned to %q by %s", gameId, owner, identity(ctx).Subject)
+	e.After = g.MarshalRecord()
+	ws.audit(e)
+
+	setOkResponse(ctx, marshalAdminGame(g, ws.baseURL(ctx)))
+}
+
+// move game of any client to trash
+func (ws *webServer) adminDeleteGame(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "adminDeleteGame")
+	gameId := ctx.UserValue("game_id").(string)
+
+	if !ws.storage.IsValidGameId(gameId) {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
+		return
+	}
+	g, err := ws.storage.Get(gameId)
+	if err == nil {
+		err = ws.storage.Delete(gameId)
+	}
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	ws.touchList()
+	ws.countRunning(g, -1)
+	logger.Infof("game %s is deleted by %s", gameId, identity(ctx).Subject)
+
+	e := newAuditEntry(ctx, auditAdminDelete, gameId)
+	e.Before = g.MarshalRecord()
+	ws.audit(e)
+
+	setOkResponse(ctx, nil)
+}
+
+// deleted games of all clients with their owners
+func (ws *webServer) getAdminTrash(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getAdminTrash")
+
+	games, err := ws.storage.ListDeleted()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+
+	res := []byte{'['}
+	for _, d := range games {
+		if len(res) > 1 {
+			res = append(res, ',')
+		}
+		g := d.Marshal()
+		res = append(res, g[:len(g)-1]...)
+		res = append(res, `,"owner":`+strconv.Quote(d.Game.Owner())+`}`...)
+	}
+	res = append(res, ']')
+
+	setOkResponse(ctx, res)
+}
+
+// restore deleted game of any client
+func (ws *webServer) adminRestoreGame(ctx *fasthttp.RequestCtx) {
+	ws.undeleteGame(ctx, "adminRestoreGame", nil)
+}
+
+func (ws *webServer) getBans(ctx *fasthttp.RequestCtx) {
+	res, err := json.Marshal(ws.bans.list())
+	if err != nil {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusInternalServerError, "can't marshal bans", err))
+		return
+	}
+	setOkResponse(ctx, res)
+}
+
+// ban client by `{"client":"key:alice","reason":"..."}`, client is identity subject or `ip:<address>`.
+// Clients with the same or higher role can't be banned
+func (ws *webServer) addBan(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "addBan")
+
+	p := ws.parserPool.Get()
+	val, err := p.ParseBytes(ctx.Request.Body())
+	if err != nil {
+		ws.parserPool.Put(p)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err).WithCode(game.CodeInvalidRequest))
+		return
+	}
+	// copy values, they are valid until parser is reused
+	b := &ban{
+		Client:  strings.TrimSpace(string(val.GetStringBytes("client"))),
+		Reason:  string(val.GetStringBytes("reason")),
+		By:      identity(ctx).Subject,
+		Created: time.Now().UTC(),
+	}
+	ws.parserPool.Put(p)
+
+	if b.Client == "" {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "client is required").WithCode(game.CodeInvalidRequest))
+		return
+	}
+	if roleRanks[ws.roleOf(b.Client)] >= roleRanks[identity(ctx).Role] {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusForbidden, "client with the same or higher role can't be banned"))
+		return
+	}
+
+	if err = ws.bans.add(b); err != nil {
+		logger.Errorln("can't save bans:", err)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusInternalServerError, "can't save bans", err))
+		return
+	}
+	logger.Infof("client %s is banned by %s: %s", b.Client, b.By, b.Reason)
+
+	res, _ := json.Marshal(b)
+	e := newAuditEntry(ctx, auditBan, b.Client)
+	e.After = res
+	ws.audit(e)
+
+	ctx.SetContentType(applicationJson)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetBody(res)
+}
+
+func (ws *webServer) removeBan(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "removeBan")
+	client := ctx.UserValue("client").(string)
+
+	found, err := ws.bans.remove(client)
+	if err != nil {
+		logger.Errorln("can't save bans:", err)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusInternalServerError, "can't save bans", err))
+		return
+	}
+	if !found {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusNotFound, "client is not banned"))
+		return
+	}
+	logger.Infof("client %s is unbanned by %s", client, identity(ctx).Subject)
+	ws.audit(newAuditEntry(ctx, auditUnban, client))
+
+	setOkResponse(ctx, nil)
+}
+
+// the last logged errors, the newest first
+func (ws *webServer) getRecentErrors(ctx *fasthttp.RequestCtx) {
+	res, err := json.Marshal(ws.recentErrors.list())
+	if err != nil {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusInternalServerError, "can't marshal errors", err))
+		return
+	}
+	setOkResponse(ctx, res)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"io"
+	"testing"
+	"tic-tac-toe/game"
+)
+
+// storage which fails to read files for snapshot
+type brokenSnapshotter struct {
+	game.Storage
+}
+
+func (brokenSnapshotter) Snapshot(w io.Writer) error {
+	return game.NewGameError(fasthttp.StatusInternalServerError, "file too large for snapshot")
+}
+
+func (brokenSnapshotter) RestoreSnapshot(r io.Reader) error {
+	return nil
+}
+
+func TestGetSnapshot(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.adminToken = "secret"
+	})
+	if err := ws.storage.Save(game.NewGame([]byte("X---O----"), game.XChar)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := doRequest(ws, "GET", "/api/admin/snapshot", "", adminTokenHeader, "secret")
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("got status %d: %s", resp.StatusCode(), resp.Body())
+	}
+	if body := resp.Body(); len(body) < 2 || body[0] != 0x1f || body[1] != 0x8b {
+		t.Fatalf("snapshot isn't gzipped: %q", body)
+	}
+
+	// failed snapshot isn't answered with empty archive
+	ws.storage = brokenSnapshotter{ws.storage}
+	resp = doRequest(ws, "GET", "/api/admin/snapshot", "", adminTokenHeader, "secret")
+	if resp.StatusCode() != fasthttp.StatusInternalServerError {
+		t.Fatalf("failed snapshot: got status %d", resp.StatusCode())
+	}
+	if ct := string(resp.Header.ContentType()); ct == "application/gzip" {
+		t.Fatal("failed snapshot is sent as archive")
+	}
+}
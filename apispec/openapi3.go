@@ -0,0 +1,252 @@
+package apispec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	openAPIVersion   = "3.0.3"
+	componentsPrefix = "#/components/schemas/"
+	mediaJSON        = "application/json"
+	mediaProblemJSON = "application/problem+json"
+)
+
+// convert spec to OpenAPI 3 json document. Error responses are described with
+// `application/problem+json` media type instead of `application/json`
+func (s *Spec) OpenAPI3() ([]byte, error) {
+	schemas := make(map[string]interface{}, len(s.Definitions))
+	for name, def := range s.Definitions {
+		schemas[name] = def.openAPI3()
+	}
+
+	paths := make(map[string]interface{}, len(s.Paths))
+	for path, ops := range s.Paths {
+		item := make(map[string]interface{}, len(ops))
+		for method, op := range ops {
+			item[method] = op.openAPI3(s.mediaTypes(op))
+		}
+		paths[path] = item
+	}
+
+	server := s.BasePath
+	if server == "" {
+		server = "/"
+	}
+
+	components := map[string]interface{}{"schemas": schemas}
+	if len(s.SecurityDefinitions) > 0 {
+		schemes := make(map[string]interface{}, len(s.SecurityDefinitions))
+		for name, def := range s.SecurityDefinitions {
+			schemes[name] = def.openAPI3()
+		}
+		components["securitySchemes"] = schemes
+	}
+
+	doc := map[string]interface{}{
+		"openapi":    openAPIVersion,
+		"info":       normalize(s.Info),
+		"servers":    []interface{}{map[string]interface{}{"url": server}},
+		"paths":      paths,
+		"components": components,
+	}
+	if len(s.Security) > 0 {
+		doc["security"] = s.Security
+	}
+	return json.Marshal(doc)
+}
+
+// basic authentication is http scheme in OpenAPI 3
+func (sc *SecurityScheme) openAPI3() map[string]interface{} {
+	res := map[string]interface{}{"type": sc.Type}
+	if sc.Type == "basic" {
+		res["type"] = "http"
+		res["scheme"] = "basic"
+	} else {
+		res["name"] = sc.Name
+		res["in"] = sc.In
+	}
+	if sc.Description != "" {
+		res["description"] = sc.Description
+	}
+	return res
+}
+
+// request and response media types of operation, operation lists override spec ones
+func (s *Spec) mediaTypes(op *Op) (consumes, produces []string) {
+	consumes, produces = s.Consumes, s.Produces
+	if len(op.Consumes) > 0 {
+		consumes = op.Consumes
+	}
+	if len(op.Produces) > 0 {
+		produces = op.Produces
+	}
+	if len(consumes) == 0 {
+		consumes = []string{mediaJSON}
+	}
+	if len(produces) == 0 {
+		produces = []string{mediaJSON}
+	}
+	return consumes, produces
+}
+
+// content object with the same schema for every media type
+func content(schema map[string]interface{}, mediaTypes []string) map[string]interface{} {
+	res := make(map[string]interface{}, len(mediaTypes))
+	for _, m := range mediaTypes {
+		res[m] = map[string]interface{}{"schema": schema}
+	}
+	return res
+}
+
+func (op *Op) openAPI3(consumes, produces []string) map[string]interface{} {
+	res := map[string]interface{}{}
+	if op.Description != "" {
+		res["description"] = op.Description
+	}
+
+	var params []interface{}
+	for _, p := range op.Parameters {
+		if p.In == "body" {
+			res["requestBody"] = map[string]interface{}{
+				"description": p.Description,
+				"required":    p.Required,
+				"content":     content(p.Schema.openAPI3(), consumes),
+			}
+			continue
+		}
+
+		schema := (&Schema{Type: p.Type, Format: p.Format, Enum: p.Enum, Minimum: p.Minimum, Maximum: p.Maximum}).openAPI3()
+		param := map[string]interface{}{
+			"name":     p.Name,
+			"in":       p.In,
+			"required": p.Required || p.In == "path",
+			"schema":   schema,
+		}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		res["parameters"] = params
+	}
+
+	// error responses are problem details, they replace json
+	var success, failure []string
+	for _, m := range produces {
+		switch m {
+		case mediaJSON:
+			success = append(success, m)
+			failure = append(failure, mediaProblemJSON)
+		case mediaProblemJSON:
+		default:
+			success = append(success, m)
+			failure = append(failure, m)
+		}
+	}
+
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	responses := make(map[string]interface{}, len(op.Responses))
+	for _, code := range codes {
+		r := op.Responses[code]
+		resp := map[string]interface{}{"description": r.Description}
+
+		if len(r.Headers) > 0 {
+			headers := make(map[string]interface{}, len(r.Headers))
+			for name, h := range r.Headers {
+				headers[name] = map[string]interface{}{
+					"description": h.Description,
+					"schema":      (&Schema{Type: h.Type, Format: h.Format}).openAPI3(),
+				}
+			}
+			resp["headers"] = headers
+		}
+
+		if r.Schema != nil {
+			media := success
+			if strings.HasPrefix(code, "4") || strings.HasPrefix(code, "5") {
+				media = failure
+			}
+			resp["content"] = content(r.Schema.openAPI3(), media)
+		}
+		responses[code] = resp
+	}
+	res["responses"] = responses
+	if op.Security != nil {
+		res["security"] = op.Security
+	}
+
+	return res
+}
+
+func (sc *Schema) openAPI3() map[string]interface{} {
+	if sc == nil {
+		return map[string]interface{}{}
+	}
+	if sc.Ref != "" {
+		return map[string]interface{}{"$ref": componentsPrefix + strings.TrimPrefix(sc.Ref, refPrefix)}
+	}
+
+	res := map[string]interface{}{}
+	set := func(key string, value interface{}, empty bool) {
+		if !empty {
+			res[key] = value
+		}
+	}
+	set("type", sc.Type, sc.Type == "")
+	set("format", sc.Format, sc.Format == "")
+	set("description", sc.Description, sc.Description == "")
+	set("required", sc.Required, len(sc.Required) == 0)
+	set("enum", normalize(sc.Enum), len(sc.Enum) == 0)
+	set("minimum", sc.Minimum, sc.Minimum == nil)
+	set("maximum", sc.Maximum, sc.Maximum == nil)
+	set("readOnly", true, !sc.ReadOnly)
+	set("nullable", true, !sc.Nullable)
+	set("example", normalize(sc.Example), sc.Example == nil)
+
+	if len(sc.Properties) > 0 {
+		props := make(map[string]interface{}, len(sc.Properties))
+		for name, p := range sc.Properties {
+			props[name] = p.openAPI3()
+		}
+		res["properties"] = props
+		res["additionalProperties"] = sc.AdditionalProperties
+	}
+	if sc.Items != nil {
+		res["items"] = sc.Items.openAPI3()
+	}
+	return res
+}
+
+// convert yaml maps to json compatible ones
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			res[fmt.Sprint(k)] = normalize(item)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			res[k] = normalize(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, item := range val {
+			res[i] = normalize(item)
+		}
+		return res
+	}
+	return v
+}
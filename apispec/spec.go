@@ -0,0 +1,209 @@
+// Package apispec validates requests and responses against swagger 2.0 API spec
+package apispec
+
+import (
+	"errors"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+)
+
+const refPrefix = "#/definitions/"
+
+type Spec struct {
+	Swagger     string                    `yaml:"swagger"`
+	Info        map[string]interface{}    `yaml:"info"`
+	Host        string                    `yaml:"host"`
+	BasePath    string                    `yaml:"basePath"`
+	Schemes     []string                  `yaml:"schemes"`
+	Consumes    []string                  `yaml:"consumes"`
+	Produces    []string                  `yaml:"produces"`
+	Definitions map[string]*Schema        `yaml:"definitions"`
+	Paths       map[string]map[string]*Op `yaml:"paths"`
+	Security    []map[string][]string     `yaml:"security"` // requirements of all operations
+
+	SecurityDefinitions map[string]*SecurityScheme `yaml:"securityDefinitions"`
+
+	routes []*route
+}
+
+type SecurityScheme struct {
+	Type        string `yaml:"type"` // apiKey or basic
+	Description string `yaml:"description"`
+	Name        string `yaml:"name"` // header or query parameter with API key
+	In          string `yaml:"in"`
+}
+
+type Op struct {
+	Description string               `yaml:"description"`
+	Consumes    []string             `yaml:"consumes"`
+	Produces    []string             `yaml:"produces"`
+	Parameters  []*Parameter         `yaml:"parameters"`
+	Responses   map[string]*Response `yaml:"responses"`
+	// overrides spec requirements if set, empty list makes operation public
+	Security []map[string][]string `yaml:"security"`
+}
+
+type Parameter struct {
+	Name        string        `yaml:"name"`
+	In          string        `yaml:"in"` // path, query, header or body
+	Description string        `yaml:"description"`
+	Required    bool          `yaml:"required"`
+	Type        string        `yaml:"type"`
+	Format      string        `yaml:"format"`
+	Enum        []interface{} `yaml:"enum"`
+	Minimum     *float64      `yaml:"minimum"`
+	Maximum     *float64      `yaml:"maximum"`
+	Schema      *Schema       `yaml:"schema"` // body parameter schema
+}
+
+type Response struct {
+	Description string             `yaml:"description"`
+	Headers     map[string]*Schema `yaml:"headers"`
+	Schema      *Schema            `yaml:"schema"`
+}
+
+type Schema struct {
+	Ref         string             `yaml:"$ref"`
+	Type        string             `yaml:"type"`
+	Format      string             `yaml:"format"`
+	Description string             `yaml:"description"`
+	Required    []string           `yaml:"required"`
+	Properties  map[string]*Schema `yaml:"properties"`
+	Items       *Schema            `yaml:"items"`
+	Enum        []interface{}      `yaml:"enum"`
+	Minimum     *float64           `yaml:"minimum"`
+	Maximum     *float64           `yaml:"maximum"`
+	ReadOnly    bool               `yaml:"readOnly"`
+	Example     interface{}        `yaml:"example"`
+	Nullable    bool               `yaml:"x-nullable"`
+	// objects are closed unless additional properties are allowed explicitly
+	AdditionalProperties bool `yaml:"additionalProperties"`
+}
+
+// route is a path template split by `/`, path params are kept in braces
+type route struct {
+	path     string
+	segments []string
+}
+
+// parse swagger 2.0 yaml document
+func Load(data []byte) (*Spec, error) {
+	s := &Spec{}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.Swagger != "2.0" {
+		return nil, errors.New("unsupported spec version " + s.Swagger)
+	}
+
+	for path := range s.Paths {
+		s.routes = append(s.routes, &route{path: path, segments: strings.Split(strings.Trim(path, "/"), "/")})
+	}
+	// static paths go first, so they win over templates with params
+	sort.Slice(s.routes, func(i, j int) bool {
+		pi, pj := strings.Count(s.routes[i].path, "{"), strings.Count(s.routes[j].path, "{")
+		if pi != pj {
+			return pi < pj
+		}
+		return s.routes[i].path < s.routes[j].path
+	})
+
+	if err := s.checkSecurity(s.Security); err != nil {
+		return nil, err
+	}
+
+	// check that all references could be resolved
+	for path, ops := range s.Paths {
+		for method, op := range ops {
+			if err := s.checkSecurity(op.Security); err != nil {
+				return nil, errors.New(method + " " + path + ": " + err.Error())
+			}
+			for _, p := range op.Parameters {
+				if err := s.checkRefs(p.Schema); err != nil {
+					return nil, errors.New(method + " " + path + ": " + err.Error())
+				}
+			}
+			for code, r := range op.Responses {
+				if err := s.checkRefs(r.Schema); err != nil {
+					return nil, errors.New(method + " " + path + " " + code + ": " + err.Error())
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// check that security requirements refer to defined schemes
+func (s *Spec) checkSecurity(requirements []map[string][]string) error {
+	for _, req := range requirements {
+		for name := range req {
+			if _, ok := s.SecurityDefinitions[name]; !ok {
+				return errors.New("undefined security scheme " + name)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Spec) checkRefs(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		if _, err := s.resolve(schema); err != nil {
+			return err
+		}
+		return nil
+	}
+	for _, p := range schema.Properties {
+		if err := s.checkRefs(p); err != nil {
+			return err
+		}
+	}
+	return s.checkRefs(schema.Items)
+}
+
+// resolve schema reference
+func (s *Spec) resolve(schema *Schema) (*Schema, error) {
+	for schema.Ref != "" {
+		def, ok := s.Definitions[strings.TrimPrefix(schema.Ref, refPrefix)]
+		if !strings.HasPrefix(schema.Ref, refPrefix) || !ok {
+			return nil, errors.New("unresolved reference " + schema.Ref)
+		}
+		schema = def
+	}
+	return schema, nil
+}
+
+// find operation by method and request path, returns path params too
+func (s *Spec) find(method, path string) (*Op, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for _, r := range s.routes {
+		if len(r.segments) != len(segments) {
+			continue
+		}
+
+		params := map[string]string{}
+		matched := true
+		for i, seg := range r.segments {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				params[seg[1:len(seg)-1]] = segments[i]
+			} else if seg != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		op, ok := s.Paths[r.path][strings.ToLower(method)]
+		if !ok {
+			return nil, nil
+		}
+		return op, params
+	}
+	return nil, nil
+}
@@ -0,0 +1,134 @@
+package apispec
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type requestCase struct {
+	method string
+	path   string
+	body   string
+	field  string // expected error field, empty if request is valid
+}
+
+func loadSpec(t *testing.T) *Spec {
+	data, err := ioutil.ReadFile("../misc/api.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSpec_ValidateRequest(t *testing.T) {
+	s := loadSpec(t)
+	gameId := "a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e"
+
+	suite := []requestCase{
+		{"GET", "/api/v1/games", "", ""},
+		{"POST", "/api/v1/games", `{"board":"---------"}`, ""},
+		{"POST", "/api/v1/games", ``, "body"},
+		{"POST", "/api/v1/games", `{"board":`, "body"},
+		{"POST", "/api/v1/games", `{}`, "body.board"},
+		{"POST", "/api/v1/games", `{"board":1}`, "body.board"},
+		{"POST", "/api/v1/games", `{"board":"X--------","color":"red"}`, "body.color"},
+		{"GET", "/api/v1/games/" + gameId, "", ""},
+		{"POST", "/api/v2/games", ``, ""},
+		{"POST", "/api/v2/games", `{"difficulty":"hard"}`, ""},
+		{"POST", "/api/v2/games", `{"difficulty":"impossible"}`, "body.difficulty"},
+		{"GET", "/api/v1/games/not-a-uuid", "", "path.game_id"},
+		{"PUT", "/api/v1/games/" + gameId, `{"id":"` + gameId + `","board":"X---O---X","status":"RUNNING"}`, ""},
+		{"PUT", "/api/v1/games/" + gameId, `{"cell":4}`, ""},
+		{"PUT", "/api/v1/games/" + gameId, `{"cell":4.5}`, "body.cell"},
+		{"PUT", "/api/v1/games/" + gameId, `{"cell":9}`, "body.cell"},
+		{"POST", "/api/v1/games/" + gameId + "/moves", `{"row":1,"col":-1}`, "body.col"},
+		// paths which are not described are not validated
+		{"GET", "/api/admin/snapshot", "junk", ""},
+	}
+
+	for idx, c := range suite {
+		err := s.ValidateRequest(&Request{Method: c.method, Path: c.path, Body: []byte(c.body)})
+		if c.field == "" {
+			if err != nil {
+				t.Fatalf("valid request (%d) %s %s is invalid: %s", idx, c.method, c.path, err)
+			}
+			continue
+		}
+		vErr, ok := err.(*ValidationError)
+		if !ok || vErr.Field != c.field {
+			t.Fatalf("request (%d) %s %s %s: expected error in %s, got %v", idx, c.method, c.path, c.body, c.field, err)
+		}
+	}
+}
+
+func TestSpec_ValidateResponse(t *testing.T) {
+	s := loadSpec(t)
+	path := "/api/v1/games/a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e"
+
+	if err := s.ValidateResponse("GET", path, 200, []byte(`{"id":"a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e","board":"X--------","status":"RUNNING"}`)); err != nil {
+		t.Fatalf("valid response is invalid: %s", err)
+	}
+	if err := s.ValidateResponse("GET", path, 200, []byte(`{"id":"a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e","board":"X--------","status":"LOST"}`)); err == nil {
+		t.Fatal("invalid status is accepted")
+	}
+	if err := s.ValidateResponse("GET", path, 418, nil); err == nil {
+		t.Fatal("undocumented status is accepted")
+	}
+
+	// nullable fields
+	path = "/api/v2/games/a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e"
+	if err := s.ValidateResponse("GET", path, 200, []byte(`{"id":"a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e","created_at":null,"next":null,"winning_line":null}`)); err != nil {
+		t.Fatalf("null is not accepted in nullable field: %s", err)
+	}
+	if err := s.ValidateResponse("GET", path, 200, []byte(`{"id":null}`)); err == nil {
+		t.Fatal("null is accepted in not nullable field")
+	}
+}
+
+func TestSpec_OpenAPI3(t *testing.T) {
+	s := loadSpec(t)
+
+	doc, err := s.OpenAPI3()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"openapi":"3.0.3"`, `"$ref":"#/components/schemas/game"`, `"application/problem+json"`, `"requestBody"`} {
+		if !strings.Contains(string(doc), expected) {
+			t.Fatalf("OpenAPI document doesn't contain %s", expected)
+		}
+	}
+	if strings.Contains(string(doc), "#/definitions/") {
+		t.Fatal("OpenAPI document contains swagger 2.0 references")
+	}
+}
+
+func TestSpec_Security(t *testing.T) {
+	s := loadSpec(t)
+
+	if s.Paths["/api/v1/openapi.json"]["get"].Security == nil {
+		t.Fatal("API document must be public")
+	}
+	if s.Paths["/api/v1/games"]["get"].Security != nil {
+		t.Fatal("games must inherit spec security requirements")
+	}
+
+	doc, err := s.OpenAPI3()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"securitySchemes":{"apiKey":{`, `"security":[{"apiKey":[]},{"bearer":[]}]`, `"security":[]`} {
+		if !strings.Contains(string(doc), expected) {
+			t.Fatalf("OpenAPI document doesn't contain %s", expected)
+		}
+	}
+
+	_, err = Load([]byte("swagger: \"2.0\"\nsecurity:\n    -   token: []\n"))
+	if err == nil || !strings.Contains(err.Error(), "undefined security scheme token") {
+		t.Fatalf("unexpected error for undefined scheme: %v", err)
+	}
+}
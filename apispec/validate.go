@@ -0,0 +1,287 @@
+package apispec
+
+import (
+	"github.com/valyala/fastjson"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	uuidPattern = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+	parserPool  fastjson.ParserPool
+)
+
+// validation error with the place where it was found, e.g. `body.cell` or `path.game_id`
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+	// lookup query and header values
+	Query  func(name string) (string, bool)
+	Header func(name string) (string, bool)
+}
+
+// validate request. Requests to paths or methods which are not described in spec are not validated.
+// Objects in body must not contain unknown fields, read-only fields are allowed and ignored
+func (s *Spec) ValidateRequest(r *Request) error {
+	op, pathParams := s.find(r.Method, r.Path)
+	if op == nil {
+		return nil
+	}
+
+	for _, p := range op.Parameters {
+		var value string
+		var found bool
+		switch p.In {
+		case "path":
+			value, found = pathParams[p.Name]
+		case "query":
+			if r.Query != nil {
+				value, found = r.Query(p.Name)
+			}
+		case "header":
+			if r.Header != nil {
+				value, found = r.Header(p.Name)
+			}
+		case "body":
+			if err := s.validateBody(p, r.Body); err != nil {
+				return err
+			}
+			continue
+		default:
+			continue
+		}
+
+		if !found {
+			if p.Required {
+				return &ValidationError{Field: p.In + "." + p.Name, Message: "required parameter is missing"}
+			}
+			continue
+		}
+		if msg := checkParam(p, value); msg != "" {
+			return &ValidationError{Field: p.In + "." + p.Name, Message: msg}
+		}
+	}
+	return nil
+}
+
+// validate response. Only responses of operations described in spec are validated
+func (s *Spec) ValidateResponse(method, path string, status int, body []byte) error {
+	op, _ := s.find(method, path)
+	if op == nil {
+		return nil
+	}
+
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		if resp, ok = op.Responses["default"]; !ok {
+			return &ValidationError{Field: "status", Message: "undocumented status " + strconv.Itoa(status)}
+		}
+	}
+	if resp.Schema == nil {
+		return nil
+	}
+
+	p := parserPool.Get()
+	defer parserPool.Put(p)
+
+	v, err := p.ParseBytes(body)
+	if err != nil {
+		return &ValidationError{Field: "body", Message: "invalid json: " + err.Error()}
+	}
+	return s.validate("body", resp.Schema, v, false)
+}
+
+func (s *Spec) validateBody(param *Parameter, body []byte) error {
+	if len(body) == 0 {
+		if param.Required {
+			return &ValidationError{Field: "body", Message: "request body is required"}
+		}
+		return nil
+	}
+
+	p := parserPool.Get()
+	defer parserPool.Put(p)
+
+	v, err := p.ParseBytes(body)
+	if err != nil {
+		return &ValidationError{Field: "body", Message: "invalid json: " + err.Error()}
+	}
+	return s.validate("body", param.Schema, v, true)
+}
+
+// validate json value against schema
+func (s *Spec) validate(field string, schema *Schema, v *fastjson.Value, request bool) error {
+	if schema == nil {
+		return nil
+	}
+	schema, err := s.resolve(schema)
+	if err != nil {
+		return &ValidationError{Field: field, Message: err.Error()}
+	}
+
+	if v.Type() == fastjson.TypeNull && schema.Nullable {
+		return nil
+	}
+	if schema.Type != "" && !typeMatches(schema.Type, v) {
+		return &ValidationError{Field: field, Message: "expected " + schema.Type + ", got " + typeName(v)}
+	}
+
+	switch v.Type() {
+	case fastjson.TypeObject:
+		o, _ := v.Object()
+		var vErr error
+		o.Visit(func(key []byte, fv *fastjson.Value) {
+			if vErr != nil {
+				return
+			}
+			name := string(key)
+			prop, ok := schema.Properties[name]
+			if !ok {
+				if schema.Properties != nil && !schema.AdditionalProperties {
+					vErr = &ValidationError{Field: field + "." + name, Message: "unknown field"}
+				}
+				return
+			}
+			if request && prop.ReadOnly {
+				// read-only fields are ignored in requests
+				return
+			}
+			vErr = s.validate(field+"."+name, prop, fv, request)
+		})
+		if vErr != nil {
+			return vErr
+		}
+		for _, name := range schema.Required {
+			if o.Get(name) == nil {
+				return &ValidationError{Field: field + "." + name, Message: "required field is missing"}
+			}
+		}
+
+	case fastjson.TypeArray:
+		items, _ := v.Array()
+		for idx, item := range items {
+			if err := s.validate(field+"["+strconv.Itoa(idx)+"]", schema.Items, item, request); err != nil {
+				return err
+			}
+		}
+
+	case fastjson.TypeString:
+		str, _ := v.StringBytes()
+		if msg := checkString(schema.Format, schema.Enum, string(str)); msg != "" {
+			return &ValidationError{Field: field, Message: msg}
+		}
+
+	case fastjson.TypeNumber:
+		if msg := checkRange(schema.Minimum, schema.Maximum, v.GetFloat64()); msg != "" {
+			return &ValidationError{Field: field, Message: msg}
+		}
+	}
+	return nil
+}
+
+func typeMatches(t string, v *fastjson.Value) bool {
+	switch t {
+	case "object":
+		return v.Type() == fastjson.TypeObject
+	case "array":
+		return v.Type() == fastjson.TypeArray
+	case "string":
+		return v.Type() == fastjson.TypeString
+	case "number":
+		return v.Type() == fastjson.TypeNumber
+	case "integer":
+		if v.Type() != fastjson.TypeNumber {
+			return false
+		}
+		_, err := v.Int64()
+		return err == nil
+	case "boolean":
+		return v.Type() == fastjson.TypeTrue || v.Type() == fastjson.TypeFalse
+	}
+	return true
+}
+
+func typeName(v *fastjson.Value) string {
+	switch v.Type() {
+	case fastjson.TypeTrue, fastjson.TypeFalse:
+		return "boolean"
+	case fastjson.TypeNumber:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+	}
+	return v.Type().String()
+}
+
+// validate path, query or header parameter
+func checkParam(p *Parameter, value string) string {
+	switch p.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "expected integer"
+		}
+		return checkRange(p.Minimum, p.Maximum, float64(n))
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "expected number"
+		}
+		return checkRange(p.Minimum, p.Maximum, n)
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "expected boolean"
+		}
+		return ""
+	}
+	return checkString(p.Format, p.Enum, value)
+}
+
+func checkString(format string, enum []interface{}, value string) string {
+	switch format {
+	case "uuid":
+		if !uuidPattern.MatchString(value) {
+			return "malformed uuid"
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "malformed date-time"
+		}
+	}
+
+	if len(enum) > 0 {
+		values := make([]string, 0, len(enum))
+		for _, e := range enum {
+			if s, ok := e.(string); ok {
+				if s == value {
+					return ""
+				}
+				values = append(values, s)
+			}
+		}
+		return "must be one of " + strings.Join(values, ", ")
+	}
+	return ""
+}
+
+func checkRange(min, max *float64, n float64) string {
+	if min != nil && n < *min {
+		return "must be >= " + strconv.FormatFloat(*min, 'f', -1, 64)
+	}
+	if max != nil && n > *max {
+		return "must be <= " + strconv.FormatFloat(*max, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"os"
+	"strconv"
+	"tic-tac-toe/game"
+)
+
+// audited actions
+const (
+	auditCreate      = "game.create"
+	auditMove        = "game.move"
+	auditDelete      = "game.delete"
+	auditRestore     = "game.restore"
+	auditArchive     = "game.archive"
+	auditFinish      = "admin.finish"
+	auditAdminDelete = "admin.delete"
+	auditAssign      = "admin.assign"
+	auditBan         = "admin.ban"
+	auditUnban       = "admin.unban"
+	auditSnapshot    = "admin.snapshot"
+)
+
+// audit entry of request. It must be created in handler, ctx can't be used by response stream writer
+func newAuditEntry(ctx *fasthttp.RequestCtx, action, target string) *game.AuditEntry {
+	e := &game.AuditEntry{
+		Action:  action,
+		IP:      clientIP(ctx),
+		Request: strconv.FormatUint(ctx.ID(), 26),
+		Target:  target,
+	}
+	if id := identity(ctx); id != nil {
+		e.Actor = id.Subject
+	}
+	return e
+}
+
+// game record for audit entry, nil game has no state
+func auditState(g *game.Game) []byte {
+	if g == nil {
+		return nil
+	}
+	return g.MarshalRecord()
+}
+
+// write entry if audit log is enabled. Action is already done, so failure is logged only
+func (ws *webServer) audit(e *game.AuditEntry) {
+	if ws.auditLog == nil {
+		return
+	}
+	if err := ws.auditLog.Append(e); err != nil {
+		ws.Log.WithFields(logrus.Fields{"req": e.Request, "f": "audit"}).Errorln("can't write audit log:", err)
+	}
+}
+
+// verify chain of audit log
+func cmdVerifyAudit() error {
+	if *auditLog == "" {
+		return errors.New("audit log is not set")
+	}
+
+	f, err := os.Open(*auditLog)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	last, n, err := game.VerifyAuditLog(f)
+	if err != nil {
+		return err
+	}
+	if last == nil {
+		fmt.Println("audit log is empty")
+		return nil
+	}
+	fmt.Printf("verified %d entries, the last one is #%d at %s\nlast hash: %s\n", n, last.Seq, last.Time.Format("2006-01-02 15:04:05"), last.Hash)
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"os"
+	"strconv"
+	"strings"
+	"tic-tac-toe/game"
+)
+
+const (
+	identityKey  = "identity" // request context key of authenticated client
+	apiKeyHeader = "X-API-Key"
+)
+
+// authenticated client
+type Identity struct {
+	Subject string // owner of games, e.g. `key:alice`
+	Method  string // authentication method
+	Role    string // player, moderator or admin
+}
+
+// authenticator checks credentials of one kind. It returns nil identity and nil error if request
+// has no such credentials
+type authenticator func(ctx *fasthttp.RequestCtx) (*Identity, error)
+
+// client authenticated by middleware, nil if authentication is disabled
+func identity(ctx *fasthttp.RequestCtx) *Identity {
+	id, _ := ctx.UserValue(identityKey).(*Identity)
+	return id
+}
+
+// owner of games created by the request, empty if authentication is disabled
+func owner(ctx *fasthttp.RequestCtx) string {
+	if id := identity(ctx); id != nil {
+		return id.Subject
+	}
+	return ""
+}
+
+// authenticate client by any of configured authenticators. All requests are allowed if there
+// are no authenticators
+func (ws *webServer) Authenticate(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	fn := func(ctx *fasthttp.RequestCtx) {
+		if len(ws.authenticators) == 0 {
+			next(ctx)
+			return
+		}
+
+		if err := ws.identify(ctx, ws.authenticators); err != nil {
+			setProblem(ctx, err)
+			return
+		}
+
+		// do next
+		next(ctx)
+	}
+	return fn
+}
+
+// find identity of client by the first authenticator which recognizes its credentials and save
+// it to request context. Banned clients are rejected
+func (ws *webServer) identify(ctx *fasthttp.RequestCtx, authenticators []authenticator) error {
+	for _, auth := range authenticators {
+		id, err := auth(ctx)
+		if err != nil {
+			ws.requestLog(ctx, "identify").Debugln("authentication failed:", err)
+			return game.NewGameError(fasthttp.StatusUnauthorized, err.Error())
+		}
+		if id == nil {
+			continue
+		}
+
+		if id.Role == "" {
+			id.Role = ws.roleOf(id.Subject)
+		}
+		if ws.bans.banned(id.Subject) {
+			ws.requestLog(ctx, "identify").Infoln("request of banned client", id.Subject)
+			return game.NewGameError(fasthttp.StatusForbidden, "client is banned")
+		}
+		ctx.SetUserValue(identityKey, id)
+		ws.requestLog(ctx, "identify").Debugln("authenticated by", id.Method, "as", id.Role)
+		return nil
+	}
+	return game.NewGameError(fasthttp.StatusUnauthorized, "authentication required")
+}
+
+// check that client owns the game. Nil identity owns all games, so nothing is checked if
+// authentication is disabled. Games created without authentication belong to nobody then
+func (id *Identity) owns(g *game.Game) bool {
+	return id == nil || id.Subject == g.Owner()
+}
+
+func checkOwner(ctx *fasthttp.RequestCtx, g *game.Game) error {
+	if identity(ctx).owns(g) {
+		return nil
+	}
+	return foreignGameError()
+}
+
+func foreignGameError() error {
+	return game.NewGameError(fasthttp.StatusForbidden, "game belongs to another client")
+}
+
+// games listed for client: owned ones or, if authentication is disabled, the ones started from
+// client address. Games of anonymous clients are still available by id
+func ownGames(ctx *fasthttp.RequestCtx, games []*game.Game) []*game.Game {
+	res := games[:0:0]
+	for _, g := range games {
+		if listsGame(ctx, g) {
+			res = append(res, g)
+		}
+	}
+	return res
+}
+
+func listsGame(ctx *fasthttp.RequestCtx, g *game.Game) bool {
+	if id := identity(ctx); id != nil {
+		return id.owns(g)
+	}
+	return g.Owner() == "" && g.Creator() == clientIP(ctx)
+}
+
+// API keys are stored as `name:sha256-hex-of-key` lines, so the file doesn't contain keys themselves
+type apiKeys map[string]string // key hash -> name
+
+func loadAPIKeys(fname string) (apiKeys, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := apiKeys{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.LastIndexByte(line, ':')
+		if idx <= 0 {
+			return nil, errors.New(fname + ": line " + strconv.Itoa(n) + ": expected name:hash")
+		}
+		hash, err := hex.DecodeString(line[idx+1:])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, errors.New(fname + ": line " + strconv.Itoa(n) + ": invalid key hash")
+		}
+		keys[string(hash)] = line[:idx]
+	}
+	return keys, scanner.Err()
+}
+
+// authenticate client by `X-API-Key` header
+func (keys apiKeys) authenticate(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	key := ctx.Request.Header.Peek(apiKeyHeader)
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	// lookup by hash doesn't leak the key via timing
+	sum := sha256.Sum256(key)
+	name, ok := keys[string(sum[:])]
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+	return &Identity{Subject: "key:" + name, Method: "api-key"}, nil
+}
+
+// generate new API key and its line for API keys file
+func generateAPIKey(name string) (key, line string, err error) {
+	if name == "" || strings.ContainsAny(name, ":\n") {
+		return "", "", errors.New("key name must be non-empty and must not contain colon")
+	}
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	key = base64.RawURLEncoding.EncodeToString(buf)
+	sum := sha256.Sum256([]byte(key))
+	return key, name + ":" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// banned client, it is identity subject or `ip:<address>`
+type ban struct {
+	Client  string    `json:"client"`
+	Reason  string    `json:"reason"`
+	By      string    `json:"by"`
+	Created time.Time `json:"created_at"`
+}
+
+// banned clients, they are saved to file if it is set
+type banList struct {
+	mu    sync.RWMutex
+	bans  map[string]*ban
+	fname string
+}
+
+func newBanList() *banList {
+	return &banList{bans: make(map[string]*ban)}
+}
+
+// load bans from file, missing file is an empty list
+func loadBanList(fname string) (*banList, error) {
+	l := newBanList()
+	l.fname = fname
+
+	data, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var bans []*ban
+	if err = json.Unmarshal(data, &bans); err != nil {
+		return nil, err
+	}
+	for _, b := range bans {
+		l.bans[b.Client] = b
+	}
+	return l, nil
+}
+
+func (l *banList) banned(client string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.bans[client]
+	return ok
+}
+
+// bans ordered by time
+func (l *banList) list() []*ban {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	res := make([]*ban, 0, len(l.bans))
+	for _, b := range l.bans {
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+	return res
+}
+
+func (l *banList) add(b *ban) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bans[b.Client] = b
+	return l.save()
+}
+
+// remove ban, false is returned if client isn't banned
+func (l *banList) remove(client string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.bans[client]; !ok {
+		return false, nil
+	}
+	delete(l.bans, client)
+	return true, l.save()
+}
+
+// write bans to temp file and rename it, so file is never half-written. Caller must hold the lock
+func (l *banList) save() error {
+	if l.fname == "" {
+		return nil
+	}
+
+	bans := make([]*ban, 0, len(l.bans))
+	for _, b := range l.bans {
+		bans = append(bans, b)
+	}
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(l.fname+".tmp", data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(l.fname+".tmp", l.fname)
+}
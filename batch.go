@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"tic-tac-toe/game"
+)
+
+const maxBatchSize = 1000 // maximum number of ids in batch request and of games in chunk selected by status
+
+type batchAction struct {
+	apply   func(s game.Storage, gameId string) error
+	get     func(s game.Storage, gameId string) (*game.Game, error) // game state for audit and counters
+	games   func(s game.Storage) ([]*game.Game, error)              // games selected by status filter
+	running int                                                     // change of running games counter
+}
+
+// audited actions of batch actions
+var batchAudit = map[string]string{
+	"delete":  auditDelete,
+	"restore": auditRestore,
+	"archive": auditArchive,
+}
+
+var batchActions = map[string]*batchAction{
+	"delete":  {apply: game.Storage.Delete, get: game.Storage.Get, games: game.Storage.List, running: -1},
+	"restore": {apply: game.Storage.Undelete, get: deletedGame, games: deletedGames, running: 1},
+	"archive": {apply: game.Storage.Archive, get: game.Storage.Get, games: game.Storage.List},
+}
+
+func deletedGame(s game.Storage, gameId string) (*game.Game, error) {
+	d, err := s.GetDeleted(gameId)
+	if err != nil {
+		return nil, err
+	}
+	return d.Game, nil
+}
+
+func deletedGames(s game.Storage) ([]*game.Game, error) {
+	deleted, err := s.ListDeleted()
+	if err != nil {
+		return nil, err
+	}
+	games := make([]*game.Game, len(deleted))
+	for idx, d := range deleted {
+		games[idx] = d.Game
+	}
+	return games, nil
+}
+
+type batchRequest struct {
+	action string
+	ids    []string
+	status string
+}
+
+// parse batch request: `{"action":"delete","ids":[...]}` or `{"action":"delete","status":"DRAW"}`
+func (ws *webServer) parseBatch(body []byte) (*batchRequest, error) {
+	p := ws.parserPool.Get()
+	defer ws.parserPool.Put(p)
+
+	val, err := p.ParseBytes(body)
+	if err != nil {
+		return nil, game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err).WithCode(game.CodeInvalidRequest)
+	}
+
+	// copy values, they are valid until parser is reused
+	req := &batchRequest{
+		action: string(val.GetStringBytes("action")),
+		status: string(val.GetStringBytes("status")),
+	}
+	for _, v := range val.GetArray("ids") {
+		id, err := v.StringBytes()
+		if err != nil {
+			return nil, game.NewGameError(fasthttp.StatusBadRequest, "ids must be strings").WithCode(game.CodeInvalidRequest)
+		}
+		req.ids = append(req.ids, string(id))
+	}
+
+	if (len(req.ids) == 0) == (req.status == "") {
+		return nil, game.NewGameError(fasthttp.StatusBadRequest, "request must contain either ids or status").WithCode(game.CodeInvalidRequest)
+	}
+	switch req.status {
+	case "", game.RUNNING, game.XWON, game.OWON, game.DRAW:
+	default:
+		return nil, game.NewGameError(fasthttp.StatusBadRequest, "unknown status "+req.status).WithCode(game.CodeInvalidRequest)
+	}
+	return req, nil
+}
+
+func (ws *webServer) batchDelete(ctx *fasthttp.RequestCtx) {
+	ws.runBatch(ctx, "delete")
+}
+
+func (ws *webServer) batchGames(ctx *fasthttp.RequestCtx) {
+	ws.runBatch(ctx, "")
+}
+
+// apply action to selected games and stream json array of per-item results. Results are
+// flushed one by one, so client could follow the progress
+func (ws *webServer) runBatch(ctx *fasthttp.RequestCtx, action string) {
+	logger := ws.requestLog(ctx, "runBatch")
+
+	req, err := ws.parseBatch(ctx.Request.Body())
+	if err != nil {
+		logger.Errorln("can't parse request:", err)
+		setProblem(ctx, err)
+		return
+	}
+	if action == "" {
+		action = req.action
+	} else if req.action != "" && req.action != action {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "action "+req.action+" is not allowed here").WithCode(game.CodeInvalidRequest))
+		return
+	}
+	if action == "" {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "action is required").WithCode(game.CodeInvalidRequest))
+		return
+	}
+	act, ok := batchActions[action]
+	if !ok {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "unknown action "+action).WithCode(game.CodeInvalidRequest))
+		return
+	}
+
+	if len(req.ids) > maxBatchSize {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "too many games in batch, maximum is "+strconv.Itoa(maxBatchSize)).WithCode(game.CodeInvalidRequest))
+		return
+	}
+	chunk := req.ids
+	if req.status != "" {
+		if chunk, err = nextBatchChunk(ws.storage, act, req.status, nil); err != nil {
+			logger.Errorln("can't list games:", err)
+			setProblem(ctx, err)
+			return
+		}
+	}
+	logger.Infof("batch %s of %d games, status %q", action, len(req.ids), req.status)
+
+	// ctx must not be used in stream writer, it runs after handler returns
+	storage := ws.storage
+	entry := newAuditEntry(ctx, batchAudit[action], "")
+	ctx.SetContentType(applicationJson)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		failed := 0
+		seen := make(map[string]bool, len(req.ids))
+
+		// client which has gone doesn't get results, so the rest of games isn't processed
+		werr := w.WriteByte('[')
+		for len(chunk) > 0 && werr == nil {
+			for _, id := range chunk {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+
+				var err error
+				var before *game.Game
+				if storage.IsValidGameId(id) {
+					before, _ = act.get(storage, id)
+					err = act.apply(storage, id)
+				} else {
+					err = game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId)
+				}
+				if err != nil {
+					failed++
+				} else {
+					ws.countRunning(before, act.running)
+					e := *entry
+					e.Target, e.Before = id, auditState(before)
+					ws.audit(&e)
+				}
+
+				item := batchResult(id, err)
+				if len(seen) > 1 {
+					item = append([]byte{','}, item...)
+				}
+				if _, werr = w.Write(item); werr == nil {
+					werr = w.Flush()
+				}
+				if werr != nil {
+					logger.Errorf("can't write result of game %s: %s", id, werr)
+					break
+				}
+			}
+
+			chunk = nil
+			if req.status != "" && werr == nil {
+				var err error
+				if chunk, err = nextBatchChunk(storage, act, req.status, seen); err != nil {
+					// response is already started, so the error ends the array
+					logger.Errorln("can't list games:", err)
+					break
+				}
+			}
+		}
+		if werr == nil {
+			if werr = w.WriteByte(']'); werr == nil {
+				werr = w.Flush()
+			}
+			if werr != nil {
+				logger.Errorln("can't write response end:", werr)
+			}
+		}
+		ws.touchList()
+		logger.Infof("batch %s done: %d games, %d failed", action, len(seen), failed)
+	})
+}
+
+// ids of the next maxBatchSize games with the status, which were not processed yet. Games are
+// listed again for every chunk, so games changed meanwhile are selected by their current status
+func nextBatchChunk(storage game.Storage, act *batchAction, status string, seen map[string]bool) ([]string, error) {
+	games, err := act.games(storage)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, g := range games {
+		if g.Status() == status && !seen[g.Id()] {
+			if ids = append(ids, g.Id()); len(ids) == maxBatchSize {
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// per-item result, errors are described like problem details
+func batchResult(id string, err error) []byte {
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("id", a.NewString(id))
+	if err == nil {
+		o.Set("status", a.NewNumberInt(fasthttp.StatusOK))
+		return o.MarshalTo(nil)
+	}
+
+	gErr := game.AsGameError(err)
+	detail := gErr.Error()
+	if gErr.Status >= fasthttp.StatusInternalServerError {
+		detail = "internal server error"
+	}
+	o.Set("status", a.NewNumberInt(gErr.Status))
+	o.Set("code", a.NewString(gErr.Code()))
+	o.Set("detail", a.NewString(detail))
+	return o.MarshalTo(nil)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"net"
+	"strings"
+	"testing"
+	"tic-tac-toe/game"
+	"time"
+)
+
+func TestBatch_StatusFilterChunks(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.adminToken = "secret"
+	})
+
+	// more games than one chunk
+	const n = maxBatchSize + 5
+	for i := 0; i < n; i++ {
+		g := game.NewGame([]byte("X---O----"), game.XChar)
+		if err := g.Finish(game.DRAW, "admin-token"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.storage.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	running := game.NewGame([]byte("X---O----"), game.XChar)
+	if err := ws.storage.Save(running); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := doRequest(ws, "POST", "/api/v1/games:batch", `{"action":"archive","status":"DRAW"}`, adminTokenHeader, "secret")
+	if resp.StatusCode() != 200 {
+		t.Fatalf("got status %d: %s", resp.StatusCode(), resp.Body())
+	}
+	results, err := fastjson.ParseBytes(resp.Body())
+	if err != nil {
+		t.Fatalf("invalid response: %s", err)
+	}
+	items := results.GetArray()
+	if len(items) != n {
+		t.Fatalf("got %d results, expected %d", len(items), n)
+	}
+	for _, item := range items {
+		if item.GetInt("status") != 200 {
+			t.Fatalf("game is not archived: %s", item)
+		}
+	}
+
+	games, err := ws.storage.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 1 || games[0].Id() != running.Id() {
+		t.Fatalf("%d games are left, expected running one only", len(games))
+	}
+	archived, err := ws.storage.ListArchived()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != n {
+		t.Fatalf("%d games are archived, expected %d", len(archived), n)
+	}
+}
+
+func TestBatch_UnknownStatus(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.adminToken = "secret"
+	})
+	resp := doRequest(ws, "POST", "/api/v1/games:batch", `{"action":"archive","status":"FINISHED"}`, adminTokenHeader, "secret")
+	if resp.StatusCode() != fasthttp.StatusBadRequest || fastjson.GetString(resp.Body(), "code") != game.CodeInvalidRequest {
+		t.Fatalf("got status %d: %s", resp.StatusCode(), resp.Body())
+	}
+}
+
+// writer of client which has gone
+type failedWriter struct{}
+
+func (failedWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection is closed")
+}
+
+// log entries of errors
+type errorHook chan *log.Entry
+
+func (h errorHook) Levels() []log.Level {
+	return []log.Level{log.ErrorLevel}
+}
+
+func (h errorHook) Fire(e *log.Entry) error {
+	select {
+	case h <- e:
+	default:
+	}
+	return nil
+}
+
+func TestBatch_WriteError(t *testing.T) {
+	ws := newTestServer(t, nil)
+	hook := make(errorHook, 10)
+	ws.Log.AddHook(hook)
+
+	// more results than pipe of streamed response buffers
+	var ids []string
+	for i := 0; i < 10; i++ {
+		g := game.NewGame([]byte("X---O----"), game.XChar)
+		if err := ws.storage.Save(g); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, `"`+g.Id()+`"`)
+	}
+
+	var req fasthttp.Request
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("/api/v1/games:batch")
+	req.Header.SetContentType(applicationJson)
+	req.SetBodyString(`{"action":"delete","ids":[` + strings.Join(ids, ",") + `]}`)
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}, testLogger())
+	// middlewares read the whole response, so handler is called directly
+	ws.batchGames(&ctx)
+	if err := ctx.Response.BodyWriteTo(failedWriter{}); err == nil {
+		t.Fatal("response is written to failed writer")
+	}
+
+	select {
+	case e := <-hook:
+		if !strings.HasPrefix(e.Message, "can't write result of game") {
+			t.Fatalf("got error %q", e.Message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write error isn't logged")
+	}
+}
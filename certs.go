@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// files of local CA, they have the same names as gencerts.sh ones
+const (
+	caCertName = "rootCA.crt"
+	caKeyName  = "rootCA.key"
+
+	caValidity      = 10 * 365 * 24 * time.Hour
+	serviceValidity = 365 * 24 * time.Hour
+)
+
+var certOrganization = []string{"tic-tac-toe corp."}
+
+// generate service cert and key signed by local CA, if both of them are missing. CA is created in
+// the same dir on the first run, it could be installed into browsers and clients to trust the service
+func ensureCerts(certFile, keyFile string, hosts []string, logger *log.Logger) error {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	switch {
+	case certErr == nil && keyErr == nil:
+		return nil
+	case !os.IsNotExist(certErr) && certErr != nil:
+		return certErr
+	case !os.IsNotExist(keyErr) && keyErr != nil:
+		return keyErr
+	case certErr == nil || keyErr == nil:
+		return errors.New("one of " + certFile + " and " + keyFile + " is missing, remove the other one to generate them")
+	}
+
+	caCertFile, caKeyFile := filepath.Join(filepath.Dir(certFile), caCertName), filepath.Join(filepath.Dir(certFile), caKeyName)
+	caCert, caKey, err := loadCA(caCertFile, caKeyFile)
+	if os.IsNotExist(err) && !exists(caCertFile) && !exists(caKeyFile) {
+		caCert, caKey, err = generateCA(caCertFile, caKeyFile)
+		if err == nil {
+			logger.Infoln("generated local CA", caCertFile)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = generateServiceCert(certFile, keyFile, hosts, caCert, caKey); err != nil {
+		return err
+	}
+	logger.Infof("generated service certificate %s for %v", certFile, hosts)
+	return nil
+}
+
+func exists(fname string) bool {
+	_, err := os.Stat(fname)
+	return err == nil
+}
+
+// load CA cert and key
+func loadCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	caKey, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !caCert.IsCA {
+		return nil, nil, errors.New(certFile + ": not a CA certificate")
+	}
+	return caCert, caKey, nil
+}
+
+func generateCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := certTemplate(pkix.Name{Organization: certOrganization, CommonName: "tic-tac-toe local CA"}, caValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.MaxPathLenZero = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writeCertAndKey(certFile, keyFile, der, key); err != nil {
+		return nil, nil, err
+	}
+	caCert, err := x509.ParseCertificate(der)
+	return caCert, key, err
+}
+
+// service cert for host names and IP addresses
+func generateServiceCert(certFile, keyFile string, hosts []string, caCert *x509.Certificate, caKey crypto.Signer) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl, err := certTemplate(pkix.Name{Organization: certOrganization, CommonName: "tic-tac-toe web service"}, serviceValidity)
+	if err != nil {
+		return err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+	if err != nil {
+		return err
+	}
+	return writeCertAndKey(certFile, keyFile, der, key)
+}
+
+func certTemplate(subject pkix.Name, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    now.Add(-time.Hour), // tolerate clock skew of clients
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// write PEM files, key is written first and is readable by owner only
+func writeCertAndKey(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(certFile), 0750); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// host names and addresses of service cert: listen addresses, public URL host and local names
+func certHosts(addrs []*listenAddr, publicURL string) []string {
+	hosts := append([]string{"localhost", "127.0.0.1", "::1"}, listenHosts(addrs)...)
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	if u, err := url.Parse(publicURL); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+
+	seen := make(map[string]bool, len(hosts))
+	res := hosts[:0]
+	for _, h := range hosts {
+		if !seen[h] {
+			seen[h] = true
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+// service certificate which is reloaded when its files are changed, e.g. renewed by gencerts.sh
+type certReloader struct {
+	certFile string
+	keyFile  string
+	log      *log.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // the latest modification time of loaded files
+}
+
+func newCertReloader(certFile, keyFile string, logger *log.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, log: logger}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, fname := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(fname)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// tls.Config callback, current certificate is used for every handshake
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// check files every interval and reload them if they are changed. If new files can't be loaded,
+// e.g. only one of them is written yet, the current certificate is kept and loading is retried later
+func (r *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := r.filesModTime()
+		r.mu.RLock()
+		changed := err == nil && modTime.After(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err = r.load(); err != nil {
+			r.log.Warnln("can't reload TLS certificate, the current one is kept:", err)
+			continue
+		}
+		r.log.Infoln("TLS certificate is reloaded from", r.certFile)
+	}
+}
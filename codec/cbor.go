@@ -0,0 +1,266 @@
+package codec
+
+import (
+	"errors"
+	"github.com/valyala/fastjson"
+	"math"
+)
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+
+	cborIndefinite = 31 // additional info of indefinite length items
+	cborBreak      = 0xff
+)
+
+type cbor struct{}
+
+// append CBOR representation of json value to dst
+func EncodeCBOR(dst []byte, v *fastjson.Value) []byte {
+	return encode(cbor{}, dst, v)
+}
+
+// item header with major type and argument
+func cborHeader(dst []byte, major byte, v uint64) []byte {
+	major <<= 5
+	switch {
+	case v < 24:
+		return append(dst, major|byte(v))
+	case v <= math.MaxUint8:
+		return append(dst, major|24, byte(v))
+	case v <= math.MaxUint16:
+		return appendUint16(append(dst, major|25), uint16(v))
+	case v <= math.MaxUint32:
+		return appendUint32(append(dst, major|26), uint32(v))
+	}
+	return appendUint64(append(dst, major|27), v)
+}
+
+func (cbor) null(dst []byte) []byte {
+	return append(dst, 0xf6)
+}
+
+func (cbor) bool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, 0xf5)
+	}
+	return append(dst, 0xf4)
+}
+
+func (cbor) int(dst []byte, v int64) []byte {
+	if v < 0 {
+		return cborHeader(dst, cborNegInt, uint64(-1-v))
+	}
+	return cborHeader(dst, cborUint, uint64(v))
+}
+
+func (cbor) uint(dst []byte, v uint64) []byte {
+	return cborHeader(dst, cborUint, v)
+}
+
+func (cbor) float(dst []byte, v float64) []byte {
+	return appendUint64(append(dst, 0xfb), math.Float64bits(v))
+}
+
+func (cbor) string(dst []byte, v []byte) []byte {
+	return append(cborHeader(dst, cborText, uint64(len(v))), v...)
+}
+
+func (cbor) arrayHeader(dst []byte, n int) []byte {
+	return cborHeader(dst, cborArray, uint64(n))
+}
+
+func (cbor) mapHeader(dst []byte, n int) []byte {
+	return cborHeader(dst, cborMap, uint64(n))
+}
+
+// decode CBOR data to json value allocated in arena. Byte strings are decoded as strings,
+// tags are skipped, undefined is decoded as null
+func DecodeCBOR(a *fastjson.Arena, data []byte) (*fastjson.Value, error) {
+	r := &reader{data: data}
+	v, err := decodeCBOR(a, r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, errors.New("unexpected data after value")
+	}
+	return v, nil
+}
+
+// read item header, argument is not set for indefinite length items
+func cborReadHeader(r *reader) (major byte, info byte, arg uint64, err error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b>>5, b&0x1f
+
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		arg, err = r.uint(1 << (info - 24))
+	case info == cborIndefinite && major >= cborBytes && major <= cborMap:
+	case info == cborIndefinite && major == cborSimple:
+		err = errors.New("unexpected break")
+	default:
+		err = errors.New("invalid CBOR item header")
+	}
+	return major, info, arg, err
+}
+
+func decodeCBOR(a *fastjson.Arena, r *reader, depth int) (*fastjson.Value, error) {
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+	major, info, arg, err := cborReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	indefinite := info == cborIndefinite
+
+	switch major {
+	case cborUint:
+		return newUint(a, arg), nil
+
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("negative integer is too large")
+		}
+		return newInt(a, -1-int64(arg)), nil
+
+	case cborBytes, cborText:
+		if !indefinite {
+			s, err := r.bytes(arg)
+			if err != nil {
+				return nil, err
+			}
+			return a.NewStringBytes(s), nil
+		}
+		// indefinite length string is a sequence of definite length chunks of the same type
+		var s []byte
+		for !r.isBreak() {
+			chunkMajor, chunkInfo, n, err := cborReadHeader(r)
+			if err != nil {
+				return nil, err
+			}
+			if chunkMajor != major || chunkInfo == cborIndefinite {
+				return nil, errors.New("invalid string chunk")
+			}
+			chunk, err := r.bytes(n)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, chunk...)
+		}
+		return a.NewStringBytes(s), nil
+
+	case cborArray:
+		if !indefinite {
+			if err := r.checkLen(arg); err != nil {
+				return nil, err
+			}
+		}
+		arr := a.NewArray()
+		for i := 0; indefinite || uint64(i) < arg; i++ {
+			if indefinite && r.isBreak() {
+				break
+			}
+			item, err := decodeCBOR(a, r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr.SetArrayItem(i, item)
+		}
+		return arr, nil
+
+	case cborMap:
+		if !indefinite {
+			if err := r.checkLen(arg); err != nil {
+				return nil, err
+			}
+		}
+		o := a.NewObject()
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite && r.isBreak() {
+				break
+			}
+			key, err := decodeCBOR(a, r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			k, err := key.StringBytes()
+			if err != nil {
+				return nil, errMapKey
+			}
+			item, err := decodeCBOR(a, r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			o.Set(string(k), item)
+		}
+		return o, nil
+
+	case cborTag:
+		return decodeCBOR(a, r, depth+1)
+	}
+
+	// simple values and floats
+	switch info {
+	case 20:
+		return a.NewFalse(), nil
+	case 21:
+		return a.NewTrue(), nil
+	case 22, 23:
+		return a.NewNull(), nil
+	case 25:
+		return newFloat(a, halfToFloat(uint16(arg)))
+	case 26:
+		return newFloat(a, float64(math.Float32frombits(uint32(arg))))
+	case 27:
+		return newFloat(a, math.Float64frombits(arg))
+	}
+	return nil, errors.New("unsupported CBOR simple value")
+}
+
+// consume break code of indefinite length item
+func (r *reader) isBreak() bool {
+	if r.pos < len(r.data) && r.data[r.pos] == cborBreak {
+		r.pos++
+		return true
+	}
+	return false
+}
+
+// convert IEEE 754 half precision number
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,143 @@
+// Package codec converts JSON values to compact binary formats and back. Only JSON data model is
+// supported: null, booleans, numbers, strings, arrays and maps with string keys
+package codec
+
+import (
+	"errors"
+	"github.com/valyala/fastjson"
+	"math"
+	"strconv"
+)
+
+const maxDepth = 64 // maximum nesting of decoded values
+
+var (
+	errTruncated = errors.New("unexpected end of data")
+	errTooDeep   = errors.New("data is nested too deep")
+	errMapKey    = errors.New("map key must be a string")
+)
+
+// encoder of one binary format
+type encoder interface {
+	null(dst []byte) []byte
+	bool(dst []byte, v bool) []byte
+	int(dst []byte, v int64) []byte
+	uint(dst []byte, v uint64) []byte
+	float(dst []byte, v float64) []byte
+	string(dst []byte, v []byte) []byte
+	arrayHeader(dst []byte, n int) []byte
+	mapHeader(dst []byte, n int) []byte
+}
+
+func encode(e encoder, dst []byte, v *fastjson.Value) []byte {
+	switch v.Type() {
+	case fastjson.TypeNull:
+		return e.null(dst)
+	case fastjson.TypeTrue:
+		return e.bool(dst, true)
+	case fastjson.TypeFalse:
+		return e.bool(dst, false)
+	case fastjson.TypeNumber:
+		if n, err := v.Int64(); err == nil {
+			return e.int(dst, n)
+		}
+		if n, err := v.Uint64(); err == nil {
+			return e.uint(dst, n)
+		}
+		return e.float(dst, v.GetFloat64())
+	case fastjson.TypeString:
+		s, _ := v.StringBytes()
+		return e.string(dst, s)
+	case fastjson.TypeArray:
+		items, _ := v.Array()
+		dst = e.arrayHeader(dst, len(items))
+		for _, item := range items {
+			dst = encode(e, dst, item)
+		}
+		return dst
+	case fastjson.TypeObject:
+		o, _ := v.Object()
+		dst = e.mapHeader(dst, o.Len())
+		o.Visit(func(key []byte, item *fastjson.Value) {
+			dst = e.string(dst, key)
+			dst = encode(e, dst, item)
+		})
+		return dst
+	}
+	return e.null(dst)
+}
+
+// json number keeps integers exact
+func newInt(a *fastjson.Arena, v int64) *fastjson.Value {
+	return a.NewNumberString(strconv.FormatInt(v, 10))
+}
+
+func newUint(a *fastjson.Arena, v uint64) *fastjson.Value {
+	return a.NewNumberString(strconv.FormatUint(v, 10))
+}
+
+// NaN and infinities can't be represented in json
+func newFloat(a *fastjson.Arena, v float64) (*fastjson.Value, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, errors.New("NaN and infinite numbers are not supported")
+	}
+	return a.NewNumberFloat64(v), nil
+}
+
+// reader of binary data
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errTruncated
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// big endian unsigned integer of n bytes
+func (r *reader) uint(n int) (uint64, error) {
+	b, err := r.bytes(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// length of array or map could not be more than number of remaining bytes, it protects from
+// allocation of huge arrays
+func (r *reader) checkLen(n uint64) error {
+	if n > uint64(len(r.data)-r.pos) {
+		return errTruncated
+	}
+	return nil
+}
+
+func appendUint16(dst []byte, v uint16) []byte {
+	return append(dst, byte(v>>8), byte(v))
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return append(dst, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
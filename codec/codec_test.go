@@ -0,0 +1,132 @@
+package codec
+
+import (
+	"encoding/hex"
+	"github.com/valyala/fastjson"
+	"testing"
+)
+
+type vector struct {
+	json string
+	data string // hex encoded
+}
+
+func TestEncode(t *testing.T) {
+	msgpackSuite := []vector{
+		{`null`, "c0"},
+		{`true`, "c3"},
+		{`0`, "00"},
+		{`127`, "7f"},
+		{`128`, "cc80"},
+		{`65536`, "ce00010000"},
+		{`-1`, "ff"},
+		{`-33`, "d0df"},
+		{`-129`, "d1ff7f"},
+		{`1.5`, "cb3ff8000000000000"},
+		{`"X--------"`, "a9582d2d2d2d2d2d2d2d"},
+		{`[1,2]`, "920102"},
+		{`{"cell":4}`, "81a463656c6c04"},
+	}
+	cborSuite := []vector{
+		{`null`, "f6"},
+		{`false`, "f4"},
+		{`23`, "17"},
+		{`24`, "1818"},
+		{`1000`, "1903e8"},
+		{`-1`, "20"},
+		{`-1000`, "3903e7"},
+		{`1.5`, "fb3ff8000000000000"},
+		{`"a"`, "6161"},
+		{`[1,[2,3]]`, "8201820203"},
+		{`{"cell":4}`, "a16463656c6c04"},
+	}
+
+	for _, c := range msgpackSuite {
+		if res := hex.EncodeToString(EncodeMsgpack(nil, fastjson.MustParse(c.json))); res != c.data {
+			t.Fatalf("msgpack %s: expected %s, got %s", c.json, c.data, res)
+		}
+	}
+	for _, c := range cborSuite {
+		if res := hex.EncodeToString(EncodeCBOR(nil, fastjson.MustParse(c.json))); res != c.data {
+			t.Fatalf("cbor %s: expected %s, got %s", c.json, c.data, res)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	suite := []string{
+		`{"id":"a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e","board":"X---O----","status":"RUNNING"}`,
+		`[null,true,false,0,-1,-4294967296,18446744073709551615,0.25,"","\"quoted\""]`,
+		`{"moves":[{"cell":0,"row":0,"col":0}],"winning_line":null,"links":{}}`,
+	}
+
+	var a fastjson.Arena
+	for _, js := range suite {
+		v := fastjson.MustParse(js)
+
+		decoded, err := DecodeMsgpack(&a, EncodeMsgpack(nil, v))
+		if err != nil {
+			t.Fatalf("msgpack %s: %s", js, err)
+		}
+		if res := string(decoded.MarshalTo(nil)); res != js {
+			t.Fatalf("msgpack: expected %s, got %s", js, res)
+		}
+
+		decoded, err = DecodeCBOR(&a, EncodeCBOR(nil, v))
+		if err != nil {
+			t.Fatalf("cbor %s: %s", js, err)
+		}
+		if res := string(decoded.MarshalTo(nil)); res != js {
+			t.Fatalf("cbor: expected %s, got %s", js, res)
+		}
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	suite := []vector{
+		{`1.5`, "f93e00"},                             // half precision
+		{`100000`, "fa47c35000"},                      // single precision
+		{`"streaming"`, "7f657374726561646d696e67ff"}, // indefinite text
+		{`[1,[2,3]]`, "9f01820203ff"},                 // indefinite array
+		{`{"a":1}`, "bf616101ff"},                     // indefinite map
+		{`"2013-03-21T20:04:00Z"`, "c074323031332d30332d32315432303a30343a30305a"}, // tagged
+	}
+
+	var a fastjson.Arena
+	for _, c := range suite {
+		data, _ := hex.DecodeString(c.data)
+		v, err := DecodeCBOR(&a, data)
+		if err != nil {
+			t.Fatalf("%s: %s", c.data, err)
+		}
+		if res := string(v.MarshalTo(nil)); res != c.json {
+			t.Fatalf("%s: expected %s, got %s", c.data, c.json, res)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	var a fastjson.Arena
+
+	for _, data := range []string{"", "a5616263", "dcffff", "c1", "8101ff", "d4", "810102"} {
+		b, _ := hex.DecodeString(data)
+		if _, err := DecodeMsgpack(&a, b); err == nil {
+			t.Fatalf("invalid msgpack %s is decoded", data)
+		}
+	}
+	for _, data := range []string{"", "6561", "9a", "a10102", "ff", "f97e00", "0000"} {
+		b, _ := hex.DecodeString(data)
+		if _, err := DecodeCBOR(&a, b); err == nil {
+			t.Fatalf("invalid cbor %s is decoded", data)
+		}
+	}
+
+	// deep nesting
+	deep := make([]byte, maxDepth+2)
+	for i := range deep {
+		deep[i] = 0x91
+	}
+	if _, err := DecodeMsgpack(&a, deep); err != errTooDeep {
+		t.Fatalf("expected %s, got %v", errTooDeep, err)
+	}
+}
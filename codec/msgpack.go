@@ -0,0 +1,237 @@
+package codec
+
+import (
+	"errors"
+	"github.com/valyala/fastjson"
+	"math"
+)
+
+type msgpack struct{}
+
+// append MessagePack representation of json value to dst
+func EncodeMsgpack(dst []byte, v *fastjson.Value) []byte {
+	return encode(msgpack{}, dst, v)
+}
+
+func (msgpack) null(dst []byte) []byte {
+	return append(dst, 0xc0)
+}
+
+func (msgpack) bool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, 0xc3)
+	}
+	return append(dst, 0xc2)
+}
+
+func (m msgpack) int(dst []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return m.uint(dst, uint64(v))
+	case v >= -32:
+		return append(dst, byte(v))
+	case v >= math.MinInt8:
+		return append(dst, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return appendUint16(append(dst, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return appendUint32(append(dst, 0xd2), uint32(v))
+	}
+	return appendUint64(append(dst, 0xd3), uint64(v))
+}
+
+func (msgpack) uint(dst []byte, v uint64) []byte {
+	switch {
+	case v <= 0x7f:
+		return append(dst, byte(v))
+	case v <= math.MaxUint8:
+		return append(dst, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return appendUint16(append(dst, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return appendUint32(append(dst, 0xce), uint32(v))
+	}
+	return appendUint64(append(dst, 0xcf), v)
+}
+
+func (msgpack) float(dst []byte, v float64) []byte {
+	return appendUint64(append(dst, 0xcb), math.Float64bits(v))
+}
+
+func (msgpack) string(dst []byte, v []byte) []byte {
+	n := len(v)
+	switch {
+	case n < 32:
+		dst = append(dst, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		dst = append(dst, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		dst = appendUint16(append(dst, 0xda), uint16(n))
+	default:
+		dst = appendUint32(append(dst, 0xdb), uint32(n))
+	}
+	return append(dst, v...)
+}
+
+func (msgpack) arrayHeader(dst []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(dst, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(dst, 0xdc), uint16(n))
+	}
+	return appendUint32(append(dst, 0xdd), uint32(n))
+}
+
+func (msgpack) mapHeader(dst []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(dst, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(dst, 0xde), uint16(n))
+	}
+	return appendUint32(append(dst, 0xdf), uint32(n))
+}
+
+// decode MessagePack data to json value allocated in arena. Binary data is decoded as string,
+// extension types are not supported
+func DecodeMsgpack(a *fastjson.Arena, data []byte) (*fastjson.Value, error) {
+	r := &reader{data: data}
+	v, err := decodeMsgpack(a, r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, errors.New("unexpected data after value")
+	}
+	return v, nil
+}
+
+func decodeMsgpack(a *fastjson.Arena, r *reader, depth int) (*fastjson.Value, error) {
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+	b, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return newInt(a, int64(b)), nil
+	case b >= 0xe0:
+		return newInt(a, int64(int8(b))), nil
+	case b&0xe0 == 0xa0:
+		return msgpackString(a, r, uint64(b&0x1f), depth)
+	case b&0xf0 == 0x90:
+		return msgpackArray(a, r, uint64(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return msgpackMap(a, r, uint64(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return a.NewNull(), nil
+	case 0xc2:
+		return a.NewFalse(), nil
+	case 0xc3:
+		return a.NewTrue(), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := r.uint(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return newUint(a, v), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		v, err := r.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// sign extension
+		shift := uint(64 - 8*size)
+		return newInt(a, int64(v<<shift)>>shift), nil
+	case 0xca:
+		v, err := r.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return newFloat(a, float64(math.Float32frombits(uint32(v))))
+	case 0xcb:
+		v, err := r.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return newFloat(a, math.Float64frombits(v))
+	case 0xd9, 0xc4:
+		return msgpackSized(a, r, 1, msgpackString, depth)
+	case 0xda, 0xc5:
+		return msgpackSized(a, r, 2, msgpackString, depth)
+	case 0xdb, 0xc6:
+		return msgpackSized(a, r, 4, msgpackString, depth)
+	case 0xdc:
+		return msgpackSized(a, r, 2, msgpackArray, depth)
+	case 0xdd:
+		return msgpackSized(a, r, 4, msgpackArray, depth)
+	case 0xde:
+		return msgpackSized(a, r, 2, msgpackMap, depth)
+	case 0xdf:
+		return msgpackSized(a, r, 4, msgpackMap, depth)
+	}
+	return nil, errors.New("unsupported MessagePack type")
+}
+
+// read length of `size` bytes and decode the value
+func msgpackSized(a *fastjson.Arena, r *reader, size int, decode func(a *fastjson.Arena, r *reader, n uint64, depth int) (*fastjson.Value, error), depth int) (*fastjson.Value, error) {
+	n, err := r.uint(size)
+	if err != nil {
+		return nil, err
+	}
+	return decode(a, r, n, depth)
+}
+
+func msgpackString(a *fastjson.Arena, r *reader, n uint64, _ int) (*fastjson.Value, error) {
+	s, err := r.bytes(n)
+	if err != nil {
+		return nil, err
+	}
+	return a.NewStringBytes(s), nil
+}
+
+func msgpackArray(a *fastjson.Arena, r *reader, n uint64, depth int) (*fastjson.Value, error) {
+	if err := r.checkLen(n); err != nil {
+		return nil, err
+	}
+	arr := a.NewArray()
+	for i := 0; i < int(n); i++ {
+		item, err := decodeMsgpack(a, r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		arr.SetArrayItem(i, item)
+	}
+	return arr, nil
+}
+
+func msgpackMap(a *fastjson.Arena, r *reader, n uint64, depth int) (*fastjson.Value, error) {
+	if err := r.checkLen(n); err != nil {
+		return nil, err
+	}
+	o := a.NewObject()
+	for i := 0; i < int(n); i++ {
+		key, err := decodeMsgpack(a, r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		k, err := key.StringBytes()
+		if err != nil {
+			return nil, errMapKey
+		}
+		item, err := decodeMsgpack(a, r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		o.Set(string(k), item)
+	}
+	return o, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/user"
+	"path/filepath"
+	"tic-tac-toe/game"
+	"time"
+)
+
+// run command given after flags, e.g. `tic-tac-toe -storagePath storage snapshot -o backup.tar.gz`
+func runCommand(args []string, logger *log.Logger) error {
+	switch args[0] {
+	case "snapshot":
+		return cmdSnapshot(args[1:], logger)
+	case "restore":
+		return cmdRestore(args[1:], logger)
+	case "fsck":
+		return cmdFsck(args[1:], logger)
+	case "genkey":
+		return cmdGenKey()
+	case "apikey":
+		return cmdAPIKey(args[1:])
+	case "verify-audit":
+		return cmdVerifyAudit()
+	case "assign-owner":
+		return cmdAssignOwner(args[1:], logger)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func cmdSnapshot(args []string, logger *log.Logger) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	out := fs.String("o", "snapshot-"+time.Now().Format("20060102150405")+".tar.gz", "path to snapshot file")
+	_ = fs.Parse(args)
+
+	storage, err := openStorage(logger)
+	if err != nil {
+		return err
+	}
+
+	// write to temp file first to not leave broken snapshot behind
+	f, err := os.Create(*out + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = storage.Snapshot(f)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), *out); err != nil {
+		return err
+	}
+
+	logger.Infoln("snapshot saved to", *out)
+	return nil
+}
+
+// replace storage content by snapshot. Service must be stopped, it doesn't see swapped storage dir and
+// overwrites restored games, so storage locked by running service is refused
+func cmdRestore(args []string, logger *log.Logger) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("i", "", "path to snapshot file, service must be stopped")
+	_ = fs.Parse(args)
+
+	if *in == "" {
+		return errors.New("snapshot file is not set")
+	}
+
+	storage, err := openStorage(logger)
+	if err != nil {
+		return err
+	}
+	if err = storage.Lock(); err != nil {
+		return err
+	}
+	defer storage.Unlock()
+
+	f, err := os.Open(filepath.Clean(*in))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return storage.RestoreSnapshot(f)
+}
+
+// check storage and print found problems
+func cmdFsck(args []string, logger *log.Logger) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	quarantine := fs.Bool("quarantine", false, "move broken files into quarantine dir inside the storage and restore games from orphaned backups")
+	_ = fs.Parse(args)
+
+	storage, err := openStorage(logger)
+	if err != nil {
+		return err
+	}
+
+	report, err := storage.Fsck(*quarantine)
+	if err != nil {
+		return err
+	}
+
+	broken := 0
+	for _, p := range report.Problems {
+		action := ""
+		switch {
+		case p.Quarantined:
+			action = " (quarantined)"
+		case p.Restored:
+			action = " (restored)"
+		}
+		fmt.Printf("%s: %s: %s%s\n", p.Name, p.Kind, p.Message, action)
+		if p.Kind != game.FsckNoChecksum && !p.Restored {
+			broken++
+		}
+	}
+	fmt.Printf("checked %d files, found %d problems\n", report.Checked, len(report.Problems))
+
+	if broken > 0 {
+		return fmt.Errorf("storage has %d broken files", broken)
+	}
+	return nil
+}
+
+// assign listed games, or all games which belong to nobody, to the owner. Service must be stopped,
+// running games are counted on start, so storage locked by running service is refused
+func cmdAssignOwner(args []string, logger *log.Logger) error {
+	fs := flag.NewFlagSet("assign-owner", flag.ExitOnError)
+	owner := fs.String("owner", "", "identity subject of the new owner, e.g. key:alice")
+	_ = fs.Parse(args)
+
+	if *owner == "" {
+		return errors.New("usage: assign-owner -owner <subject> [game id ...]")
+	}
+
+	storage, err := openStorage(logger)
+	if err != nil {
+		return err
+	}
+	if err = storage.Lock(); err != nil {
+		return err
+	}
+	defer storage.Unlock()
+
+	// changes are audited like the ones made by admin API
+	var audit *game.AuditLog
+	if *auditLog != "" {
+		if audit, err = game.OpenAuditLog(*auditLog, logger); err != nil {
+			return err
+		}
+		defer audit.Close()
+	}
+
+	var games []*game.Game
+	if ids := fs.Args(); len(ids) > 0 {
+		for _, id := range ids {
+			g, err := storage.Get(id)
+			if err != nil {
+				return fmt.Errorf("game %s: %s", id, err)
+			}
+			games = append(games, g)
+		}
+	} else {
+		all, err := storage.List()
+		if err != nil {
+			return err
+		}
+		for _, g := range all {
+			if g.Owner() == "" {
+				games = append(games, g)
+			}
+		}
+	}
+
+	actor := commandActor()
+	for _, g := range games {
+		e := &game.AuditEntry{Action: auditAssign, Actor: actor, Target: g.Id(), Before: g.MarshalRecord()}
+		g.SetOwner(*owner)
+		if err = storage.Save(g); err != nil {
+			return fmt.Errorf("game %s: %s", g.Id(), err)
+		}
+		if audit != nil {
+			e.After = g.MarshalRecord()
+			if err = audit.Append(e); err != nil {
+				return fmt.Errorf("game %s is assigned, but audit log isn't written: %s", g.Id(), err)
+			}
+		}
+	}
+	logger.Infof("%d games are assigned to %s", len(games), *owner)
+	return nil
+}
+
+// audit actor of commands, it is OS user who runs the command
+func commandActor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// print new storage encryption key
+func cmdGenKey() error {
+	key, err := game.GenerateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+// print new API key for client and the line to add to API keys file
+func cmdAPIKey(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: apikey <name>")
+	}
+	key, line, err := generateAPIKey(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println("key:", key)
+	fmt.Println("line for API keys file:", line)
+	return nil
+}
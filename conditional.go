@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/hex"
+	"github.com/valyala/fasthttp"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+	"tic-tac-toe/game"
+	"time"
+)
+
+// compress responses negotiated via Accept-Encoding, brotli is preferred over gzip
+func (ws *webServer) Compress(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	compress := fasthttp.CompressHandlerBrotliLevel(next, fasthttp.CompressBrotliDefaultCompression, fasthttp.CompressDefaultCompression)
+	fn := func(ctx *fasthttp.RequestCtx) {
+		compress(ctx)
+		ctx.Response.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+	}
+	return fn
+}
+
+// weak validator of game state. It's weak since representations in all formats and encodings
+// are equivalent
+func gameETag(games ...*game.Game) string {
+	h := fnv.New64a()
+	for _, g := range games {
+		h.Write(g.MarshalRecord())
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// time of last change of the list of games, deleted and restored games don't change games themselves
+func (ws *webServer) listModified(games []*game.Game) time.Time {
+	modified := time.Unix(0, atomic.LoadInt64(&ws.listChanged))
+	for _, g := range games {
+		if g.Updated().After(modified) {
+			modified = g.Updated()
+		}
+	}
+	return modified
+}
+
+func (ws *webServer) touchList() {
+	atomic.StoreInt64(&ws.listChanged, time.Now().UnixNano())
+}
+
+// set validators and check conditional request headers. If client has the same representation
+// already, response is set to `304 Not Modified` and true is returned
+func notModified(ctx *fasthttp.RequestCtx, etag string, modified time.Time) bool {
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+	lastModified := lastModifiedAt(modified, time.Now())
+	if !lastModified.IsZero() {
+		ctx.Response.Header.SetLastModified(lastModified)
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if inm := string(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch)); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if !notModifiedSince(ctx, lastModified) {
+		return false
+	}
+
+	ctx.ResetBody()
+	ctx.SetStatusCode(fasthttp.StatusNotModified)
+	return true
+}
+
+// Last-Modified value with 1s resolution. Modification time is rounded up, so other changes in
+// the same second aren't older than it. It's zero until that second is over, otherwise client
+// could get the same value for the next change in this second
+func lastModifiedAt(modified, now time.Time) time.Time {
+	if modified.IsZero() {
+		return time.Time{}
+	}
+	res := modified.Truncate(time.Second)
+	if res.Before(modified) {
+		res = res.Add(time.Second)
+	}
+	if res.After(now) {
+		return time.Time{}
+	}
+	return res
+}
+
+// check If-Modified-Since against Last-Modified value
+func notModifiedSince(ctx *fasthttp.RequestCtx, lastModified time.Time) bool {
+	ims := ctx.Request.Header.Peek(fasthttp.HeaderIfModifiedSince)
+	if len(ims) == 0 || lastModified.IsZero() {
+		return false
+	}
+	t, err := fasthttp.ParseHTTPDate(ims)
+	return err == nil && !t.Before(lastModified)
+}
+
+// weak comparison of If-None-Match list with etag
+func etagMatches(inm, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, item := range strings.Split(inm, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" || strings.TrimPrefix(item, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"testing"
+	"tic-tac-toe/game"
+	"time"
+)
+
+func TestLastModifiedAt(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 10, 500e6, time.UTC)
+
+	tests := []struct {
+		name     string
+		modified time.Time
+		res      time.Time
+	}{
+		{"zero", time.Time{}, time.Time{}},
+		{"rounded up", now.Add(-2 * time.Second), time.Date(2020, 1, 1, 0, 0, 9, 0, time.UTC)},
+		{"whole second", time.Date(2020, 1, 1, 0, 0, 9, 0, time.UTC), time.Date(2020, 1, 1, 0, 0, 9, 0, time.UTC)},
+		{"previous second", now.Add(-time.Second), time.Date(2020, 1, 1, 0, 0, 10, 0, time.UTC)},
+		{"current second", now.Add(-100 * time.Millisecond), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := lastModifiedAt(tt.modified, now); !res.Equal(tt.res) {
+				t.Errorf("got %s, expected %s", res, tt.res)
+			}
+		})
+	}
+}
+
+// game saved with update time in the past
+func saveGameUpdatedAt(t *testing.T, ws *webServer, updated time.Time) *game.Game {
+	g := game.NewGame([]byte("X---O----"), game.XChar)
+	rec := bytes.Replace(g.MarshalRecord(), []byte(g.Updated().Format(time.RFC3339Nano)), []byte(updated.Format(time.RFC3339Nano)), 1)
+	g, err := game.Unmarshal(new(fastjson.Parser), rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ws.storage.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGetGame_Conditional(t *testing.T) {
+	ws := newTestServer(t, nil)
+	updated := time.Now().Add(-time.Minute)
+	g := saveGameUpdatedAt(t, ws, updated)
+	uri := "/api/v1/games/" + g.Id()
+
+	resp := doRequest(ws, "GET", uri, "")
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode())
+	}
+	etag := string(resp.Header.Peek(fasthttp.HeaderETag))
+	lastModified := string(resp.Header.Peek(fasthttp.HeaderLastModified))
+	if etag == "" || lastModified == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q", etag, lastModified)
+	}
+	if lm, err := fasthttp.ParseHTTPDate([]byte(lastModified)); err != nil || lm.Before(updated) {
+		t.Fatalf("Last-Modified %q is older than the game", lastModified)
+	}
+
+	before := fasthttp.AppendHTTPDate(nil, updated.Add(-time.Second))
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"same etag", []string{fasthttp.HeaderIfNoneMatch, etag}, fasthttp.StatusNotModified},
+		{"strong etag", []string{fasthttp.HeaderIfNoneMatch, etag[2:]}, fasthttp.StatusNotModified},
+		{"etag in list", []string{fasthttp.HeaderIfNoneMatch, `"other", ` + etag}, fasthttp.StatusNotModified},
+		{"any etag", []string{fasthttp.HeaderIfNoneMatch, "*"}, fasthttp.StatusNotModified},
+		{"other etag", []string{fasthttp.HeaderIfNoneMatch, `W/"other"`}, fasthttp.StatusOK},
+		{"etag takes precedence", []string{fasthttp.HeaderIfNoneMatch, `W/"other"`, fasthttp.HeaderIfModifiedSince, lastModified}, fasthttp.StatusOK},
+		{"not modified since", []string{fasthttp.HeaderIfModifiedSince, lastModified}, fasthttp.StatusNotModified},
+		{"modified since", []string{fasthttp.HeaderIfModifiedSince, string(before)}, fasthttp.StatusOK},
+		{"invalid date", []string{fasthttp.HeaderIfModifiedSince, "yesterday"}, fasthttp.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(ws, "GET", uri, "", tt.headers...)
+			if resp.StatusCode() != tt.status {
+				t.Fatalf("got status %d, expected %d", resp.StatusCode(), tt.status)
+			}
+			if tt.status == fasthttp.StatusNotModified && len(resp.Body()) > 0 {
+				t.Errorf("304 response has body %s", resp.Body())
+			}
+			if v := string(resp.Header.Peek(fasthttp.HeaderETag)); v != etag {
+				t.Errorf("got ETag %q, expected %q", v, etag)
+			}
+		})
+	}
+}
+
+func TestGetGame_ModifiedInSameSecond(t *testing.T) {
+	ws := newTestServer(t, nil)
+	// the second of modification isn't over yet
+	g := saveGameUpdatedAt(t, ws, time.Now().Add(2*time.Second))
+	uri := "/api/v1/games/" + g.Id()
+
+	// game could change again in this second, so its time isn't a validator yet
+	resp := doRequest(ws, "GET", uri, "")
+	if v := resp.Header.Peek(fasthttp.HeaderLastModified); len(v) > 0 {
+		t.Fatalf("Last-Modified %q is sent for game changed in this second", v)
+	}
+	now := string(fasthttp.AppendHTTPDate(nil, time.Now().Add(2*time.Second)))
+	if resp = doRequest(ws, "GET", uri, "", fasthttp.HeaderIfModifiedSince, now); resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("got status %d, expected 200", resp.StatusCode())
+	}
+}
+
+func TestGetAllGames_Conditional(t *testing.T) {
+	ws := newTestServer(t, nil)
+	saveGameUpdatedAt(t, ws, time.Now().Add(-time.Minute))
+
+	resp := doRequest(ws, "GET", "/api/v1/games", "")
+	etag := string(resp.Header.Peek(fasthttp.HeaderETag))
+	if resp = doRequest(ws, "GET", "/api/v1/games", "", fasthttp.HeaderIfNoneMatch, etag); resp.StatusCode() != fasthttp.StatusNotModified {
+		t.Fatalf("same list: got status %d, expected 304", resp.StatusCode())
+	}
+
+	if resp = doRequest(ws, "POST", "/api/v1/games", `{"board":"---------"}`); resp.StatusCode() != fasthttp.StatusCreated {
+		t.Fatalf("can't start game: %d %s", resp.StatusCode(), resp.Body())
+	}
+	if resp = doRequest(ws, "GET", "/api/v1/games", "", fasthttp.HeaderIfNoneMatch, etag); resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("changed list: got status %d, expected 200", resp.StatusCode())
+	}
+}
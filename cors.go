@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// response headers which browser clients could read
+var corsExposedHeaders = strings.Join([]string{
+	fasthttp.HeaderLocation,
+	fasthttp.HeaderETag,
+	fasthttp.HeaderRetryAfter,
+	idempotentReplayedHeader,
+}, ", ")
+
+// cross-origin requests of browser clients. Preflight requests are answered for every registered
+// route and method, CORS is disabled if there are no allowed origins
+type corsConfig struct {
+	origins     map[string]bool
+	anyOrigin   bool   // `*` is allowed
+	methods     string // comma separated methods allowed in preflight
+	headers     string // comma separated request headers allowed in preflight
+	credentials bool   // browser could send cookies and authorization headers
+	maxAge      int    // seconds preflight response could be cached for
+}
+
+// parse comma separated origins, e.g. `https://app.example.com,http://localhost:3000` or `*`. Any
+// origin with credentials is refused, it would let every site act on behalf of logged in users
+func newCORSConfig(origins, methods, headers string, credentials bool, maxAge int) (*corsConfig, error) {
+	c := &corsConfig{
+		origins:     make(map[string]bool),
+		methods:     normalizeList(methods, strings.ToUpper),
+		headers:     normalizeList(headers, textproto.CanonicalMIMEHeaderKey),
+		credentials: credentials,
+		maxAge:      maxAge,
+	}
+	for _, o := range strings.Split(origins, ",") {
+		o = strings.TrimRight(strings.TrimSpace(o), "/")
+		if o == "*" {
+			c.anyOrigin = true
+		} else if o != "" {
+			c.origins[strings.ToLower(o)] = true
+		}
+	}
+	if !c.anyOrigin && len(c.origins) == 0 {
+		return nil, nil
+	}
+	if c.anyOrigin && credentials {
+		return nil, errors.New("any origin `*` can't be allowed with credentials, list origins explicitly")
+	}
+	return c, nil
+}
+
+func normalizeList(list string, normalize func(string) string) string {
+	var res []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, normalize(v))
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
+// value of Access-Control-Allow-Origin, empty if origin is not allowed. Only listed origins are
+// echoed, any other one gets `*`
+func (c *corsConfig) allowOrigin(origin string) string {
+	switch {
+	case c.origins[strings.ToLower(origin)]:
+		return origin
+	case c.anyOrigin:
+		return "*"
+	}
+	return ""
+}
+
+// responses for listed origins differ by origin, `*` is the same for all of them
+func (c *corsConfig) vary(ctx *fasthttp.RequestCtx) {
+	if len(c.origins) > 0 {
+		ctx.Response.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderOrigin)
+	}
+}
+
+func (c *corsConfig) allowsMethod(method string) bool {
+	for _, m := range strings.Split(c.methods, ", ") {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// answer preflight requests and add CORS headers to responses for allowed origins. It runs inside
+// Proxy, so client address resolved by proxy is logged, and before other middlewares, as preflight
+// requests carry no credentials and must not reach Authorize
+func (ws *webServer) CORS(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	if ws.cors == nil {
+		return next
+	}
+
+	fn := func(ctx *fasthttp.RequestCtx) {
+		origin := string(ctx.Request.Header.Peek(fasthttp.HeaderOrigin))
+		if origin == "" {
+			// not a cross-origin request, but response depends on origin and caches must know it
+			next(ctx)
+			ws.cors.vary(ctx)
+			return
+		}
+
+		allowOrigin := ws.cors.allowOrigin(origin)
+		method := string(ctx.Request.Header.Peek(fasthttp.HeaderAccessControlRequestMethod))
+		if ctx.IsOptions() && method != "" {
+			ws.preflight(ctx, allowOrigin, method)
+			return
+		}
+
+		// do next
+		next(ctx)
+
+		ws.cors.vary(ctx)
+		if allowOrigin == "" {
+			return
+		}
+		ctx.Response.Header.Set(fasthttp.HeaderAccessControlAllowOrigin, allowOrigin)
+		ctx.Response.Header.Set(fasthttp.HeaderAccessControlExposeHeaders, corsExposedHeaders)
+		if ws.cors.credentials {
+			ctx.Response.Header.Set(fasthttp.HeaderAccessControlAllowCredentials, "true")
+		}
+	}
+	return fn
+}
+
+// answer preflight request. Route must be registered for requested method and both origin and
+// method must be allowed, otherwise response has no CORS headers and browser blocks the request
+func (ws *webServer) preflight(ctx *fasthttp.RequestCtx, allowOrigin, method string) {
+	h := &ctx.Response.Header
+	h.Add(fasthttp.HeaderVary, fasthttp.HeaderOrigin)
+	h.Add(fasthttp.HeaderVary, fasthttp.HeaderAccessControlRequestMethod)
+	h.Add(fasthttp.HeaderVary, fasthttp.HeaderAccessControlRequestHeaders)
+
+	if handler, _ := ws.router.Lookup(method, string(ctx.Path()), nil); handler == nil {
+		ws.requestLog(ctx, "preflight").Debugln("preflight for unknown route:", method, string(ctx.Path()))
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+	if allowOrigin == "" || !ws.cors.allowsMethod(method) {
+		ws.requestLog(ctx, "preflight").Debugln("preflight is rejected:", string(ctx.Request.Header.Peek(fasthttp.HeaderOrigin)), method, string(ctx.Path()))
+		return
+	}
+
+	h.Set(fasthttp.HeaderAccessControlAllowOrigin, allowOrigin)
+	h.Set(fasthttp.HeaderAccessControlAllowMethods, ws.cors.methods)
+	if ws.cors.headers != "" {
+		h.Set(fasthttp.HeaderAccessControlAllowHeaders, ws.cors.headers)
+	}
+	if ws.cors.credentials {
+		h.Set(fasthttp.HeaderAccessControlAllowCredentials, "true")
+	}
+	if ws.cors.maxAge > 0 {
+		h.Set(fasthttp.HeaderAccessControlMaxAge, strconv.Itoa(ws.cors.maxAge))
+	}
+}
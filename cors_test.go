@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func TestNewCORSConfig(t *testing.T) {
+	if c, err := newCORSConfig("", "GET", "", false, 0); c != nil || err != nil {
+		t.Errorf("no origins: got %v, %v, expected disabled CORS", c, err)
+	}
+	if _, err := newCORSConfig("*", "GET", "", true, 0); err == nil {
+		t.Error("any origin is allowed with credentials")
+	}
+	if _, err := newCORSConfig("https://app.example.com", "GET", "", true, 0); err != nil {
+		t.Errorf("listed origin with credentials: %s", err)
+	}
+}
+
+func TestCORSConfig_AllowOrigin(t *testing.T) {
+	listed, _ := newCORSConfig("https://App.example.com/, http://localhost:3000", "GET", "", true, 0)
+	wildcard, _ := newCORSConfig("*", "GET", "", false, 0)
+
+	tests := []struct {
+		name   string
+		c      *corsConfig
+		origin string
+		allow  string
+	}{
+		{"listed", listed, "https://app.example.com", "https://app.example.com"},
+		{"listed in other case", listed, "https://APP.example.com", "https://APP.example.com"},
+		{"listed with port", listed, "http://localhost:3000", "http://localhost:3000"},
+		{"other port", listed, "http://localhost:3001", ""},
+		{"other scheme", listed, "http://app.example.com", ""},
+		{"suffix", listed, "https://app.example.com.evil.com", ""},
+		{"any", wildcard, "https://evil.com", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := tt.c.allowOrigin(tt.origin); res != tt.allow {
+				t.Errorf("got %q, expected %q", res, tt.allow)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.cors, _ = newCORSConfig("https://app.example.com", "GET,POST", "Content-Type,X-API-Key", true, 600)
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		uri         string
+		headers     []string
+		status      int
+		allowOrigin string
+		allowMethod string
+	}{
+		{"preflight", "OPTIONS", "/api/v1/games", []string{"Origin", "https://app.example.com", "Access-Control-Request-Method", "POST"},
+			fasthttp.StatusNoContent, "https://app.example.com", "GET, POST"},
+		{"preflight of other origin", "OPTIONS", "/api/v1/games", []string{"Origin", "https://evil.com", "Access-Control-Request-Method", "POST"},
+			fasthttp.StatusNoContent, "", ""},
+		{"preflight of not allowed method", "OPTIONS", "/api/v1/games/00000000-0000-4000-8000-000000000000", []string{"Origin", "https://app.example.com", "Access-Control-Request-Method", "PUT"},
+			fasthttp.StatusNoContent, "", ""},
+		{"preflight of unknown route", "OPTIONS", "/api/v1/unknown", []string{"Origin", "https://app.example.com", "Access-Control-Request-Method", "GET"},
+			fasthttp.StatusNotFound, "", ""},
+		{"request", "GET", "/api/v1/games", []string{"Origin", "https://app.example.com"},
+			fasthttp.StatusOK, "https://app.example.com", ""},
+		{"request of other origin", "GET", "/api/v1/games", []string{"Origin", "https://evil.com"},
+			fasthttp.StatusOK, "", ""},
+		{"same origin request", "GET", "/api/v1/games", nil,
+			fasthttp.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(ws, tt.method, tt.uri, "", tt.headers...)
+			if resp.StatusCode() != tt.status {
+				t.Fatalf("got status %d, expected %d", resp.StatusCode(), tt.status)
+			}
+			if v := string(resp.Header.Peek(fasthttp.HeaderAccessControlAllowOrigin)); v != tt.allowOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, expected %q", v, tt.allowOrigin)
+			}
+			if v := string(resp.Header.Peek(fasthttp.HeaderAccessControlAllowMethods)); v != tt.allowMethod {
+				t.Errorf("got Access-Control-Allow-Methods %q, expected %q", v, tt.allowMethod)
+			}
+			if v := string(resp.Header.Peek(fasthttp.HeaderVary)); v == "" {
+				t.Error("Vary header is missing")
+			}
+			credentials := string(resp.Header.Peek(fasthttp.HeaderAccessControlAllowCredentials))
+			if (tt.allowOrigin != "") != (credentials == "true") {
+				t.Errorf("got Access-Control-Allow-Credentials %q", credentials)
+			}
+		})
+	}
+}
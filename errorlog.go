@@ -0,0 +1,61 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const recentErrorsSize = 100 // number of errors kept for admins
+
+type loggedError struct {
+	Time     time.Time `json:"time"`
+	Level    string    `json:"level"`
+	Message  string    `json:"message"`
+	Request  string    `json:"req,omitempty"`
+	Function string    `json:"f,omitempty"`
+}
+
+// logger hook which keeps the last errors in ring buffer
+type recentErrors struct {
+	mu     sync.Mutex
+	errors []loggedError
+	next   int
+}
+
+func newRecentErrors() *recentErrors {
+	return &recentErrors{errors: make([]loggedError, 0, recentErrorsSize)}
+}
+
+func (r *recentErrors) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel}
+}
+
+func (r *recentErrors) Fire(entry *log.Entry) error {
+	e := loggedError{Time: entry.Time, Level: entry.Level.String(), Message: entry.Message}
+	e.Request, _ = entry.Data["req"].(string)
+	e.Function, _ = entry.Data["f"].(string)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.errors) < recentErrorsSize {
+		r.errors = append(r.errors, e)
+	} else {
+		r.errors[r.next] = e
+	}
+	r.next = (r.next + 1) % recentErrorsSize
+	return nil
+}
+
+// errors from the newest one
+func (r *recentErrors) list() []loggedError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]loggedError, 0, len(r.errors))
+	for i := 1; i <= len(r.errors); i++ {
+		res = append(res, r.errors[(r.next-i+recentErrorsSize)%recentErrorsSize])
+	}
+	return res
+}
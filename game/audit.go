@@ -0,0 +1,247 @@
+package game
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fastjson"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// every entry ends with hash of the previous entry hash and entry itself, so changed, inserted or
+// removed entries break the chain
+const (
+	auditHashField    = `,"hash":"`
+	auditHashLen      = sha256.Size * 2
+	auditHashSuffix   = len(auditHashField) + auditHashLen + len(`"}`)
+	maxAuditEntrySize = 1 << 20
+)
+
+// audited action of client. Before and after are game records, they are empty if there is no such state
+type AuditEntry struct {
+	Seq     uint64
+	Time    time.Time
+	Action  string
+	Actor   string // identity subject, empty for anonymous client
+	IP      string
+	Request string
+	Target  string // game id or client
+	Before  []byte
+	After   []byte
+	Prev    string // hash of the previous entry, empty for the first one
+	Hash    string
+}
+
+// create json line without hash
+func (e *AuditEntry) marshal() []byte {
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("seq", a.NewNumberString(strconv.FormatUint(e.Seq, 10)))
+	o.Set("time", a.NewString(e.Time.UTC().Format(time.RFC3339Nano)))
+	o.Set("action", a.NewString(e.Action))
+	o.Set("actor", a.NewString(e.Actor))
+	o.Set("ip", a.NewString(e.IP))
+	o.Set("req", a.NewString(e.Request))
+	o.Set("target", a.NewString(e.Target))
+	o.Set("prev", a.NewString(e.Prev))
+	res := o.MarshalTo(nil)
+
+	// game records are json already
+	res = res[:len(res)-1]
+	for _, state := range []struct {
+		name  string
+		value []byte
+	}{{"before", e.Before}, {"after", e.After}} {
+		res = append(res, `,"`+state.name+`":`...)
+		if len(state.value) == 0 {
+			res = append(res, "null"...)
+		} else {
+			res = append(res, state.value...)
+		}
+	}
+	return append(res, '}')
+}
+
+func auditHash(prev string, entry []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prev))
+	h.Write(entry)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// append-only audit log file
+type AuditLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	seq  uint64
+	last string // hash of the last entry
+}
+
+// open audit log and verify its chain, new entries are appended to the verified chain. Entry which
+// was torn by crash during write is truncated, the chain is verified without it
+func OpenAuditLog(fname string, logger *log.Logger) (*AuditLog, error) {
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	torn, err := truncateTornAuditEntry(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if len(torn) > 0 {
+		logger.Warnf("audit log %s ends with torn entry of %d bytes, it is truncated: %q", fname, len(torn), torn)
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	last, _, err := VerifyAuditLog(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	l := &AuditLog{f: f}
+	if last != nil {
+		l.seq, l.last = last.Seq, last.Hash
+	}
+	return l, nil
+}
+
+// remove the last line if it has no line end, entries are written with it at once, so such line
+// is left by crash during write. Removed bytes are returned
+func truncateTornAuditEntry(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+
+	// torn entry isn't longer than the longest one
+	tail := int64(maxAuditEntrySize + 1)
+	if tail > size {
+		tail = size
+	}
+	buf := make([]byte, tail)
+	if _, err = f.ReadAt(buf, size-tail); err != nil {
+		return nil, err
+	}
+	if buf[len(buf)-1] == '\n' {
+		return nil, nil
+	}
+	i := bytes.LastIndexByte(buf, '\n')
+	if i < 0 && tail < size {
+		return nil, errors.New("audit log ends with line longer than " + strconv.Itoa(maxAuditEntrySize) + " bytes")
+	}
+
+	torn := buf[i+1:]
+	if err = f.Truncate(size - int64(len(torn))); err != nil {
+		return nil, err
+	}
+	return torn, f.Sync()
+}
+
+// append entry to the chain, sequence number, previous and own hashes are set by log
+func (l *AuditLog) Append(e *AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	e.Prev = l.last
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	entry := e.marshal()
+	e.Hash = auditHash(e.Prev, entry)
+
+	line := append(entry[:len(entry)-1], auditHashField+e.Hash+"\"}\n"...)
+	if _, err := l.f.Write(line); err != nil {
+		return err
+	}
+	// entry must survive crash, it describes the action which is already done
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.seq, l.last = e.Seq, e.Hash
+	return nil
+}
+
+func (l *AuditLog) Close() error {
+	return l.f.Close()
+}
+
+// check chain of audit log entries, the last entry and number of entries are returned. Removal of
+// the last entries can't be detected by chain, compare the last hash with a copy kept elsewhere
+func VerifyAuditLog(r io.Reader) (*AuditEntry, int, error) {
+	var last *AuditEntry
+	var p fastjson.Parser
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxAuditEntrySize)
+	n := 0
+	for ; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		e, err := verifyAuditEntry(&p, line, last)
+		if err != nil {
+			return last, n, errors.New("audit log entry " + strconv.Itoa(n+1) + ": " + err.Error())
+		}
+		last = e
+	}
+	if err := scanner.Err(); err != nil {
+		return last, n, errors.New("audit log entry " + strconv.Itoa(n+1) + ": " + err.Error())
+	}
+	return last, n, nil
+}
+
+// check entry against the previous one
+func verifyAuditEntry(p *fastjson.Parser, line []byte, prev *AuditEntry) (*AuditEntry, error) {
+	if len(line) < auditHashSuffix || !bytes.Equal(line[len(line)-auditHashSuffix:len(line)-auditHashSuffix+len(auditHashField)], []byte(auditHashField)) {
+		return nil, errors.New("no hash")
+	}
+	entry := append(line[:len(line)-auditHashSuffix:len(line)-auditHashSuffix], '}')
+	hash := string(line[len(line)-auditHashLen-2 : len(line)-2])
+
+	v, err := p.ParseBytes(line)
+	if err != nil {
+		return nil, err
+	}
+	e := &AuditEntry{
+		Seq:    v.GetUint64("seq"),
+		Action: string(v.GetStringBytes("action")),
+		Target: string(v.GetStringBytes("target")),
+		Prev:   string(v.GetStringBytes("prev")),
+		Hash:   string(v.GetStringBytes("hash")),
+	}
+	if e.Time, err = time.Parse(time.RFC3339Nano, string(v.GetStringBytes("time"))); err != nil {
+		return nil, errors.New("invalid time")
+	}
+
+	switch {
+	case e.Hash != hash:
+		return nil, errors.New("hash is not the last field")
+	case prev == nil && (e.Seq != 1 || e.Prev != ""):
+		return nil, errors.New("chain doesn't start with the first entry")
+	case prev != nil && e.Seq != prev.Seq+1:
+		return nil, errors.New("sequence number " + strconv.FormatUint(e.Seq, 10) + " follows " + strconv.FormatUint(prev.Seq, 10))
+	case prev != nil && e.Prev != prev.Hash:
+		return nil, errors.New("previous hash mismatch")
+	case auditHash(e.Prev, entry) != hash:
+		return nil, errors.New("hash mismatch, entry is changed")
+	}
+	return e, nil
+}
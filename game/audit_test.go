@@ -0,0 +1,147 @@
+package game
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestAuditLog(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tic-tac-toe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	fname := filepath.Join(dir, "audit.log")
+	l, err := OpenAuditLog(fname, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	g := NewGame([]byte("X--------"), XChar)
+	before := g.MarshalRecord()
+	g.MakeMove()
+	for _, e := range []*AuditEntry{
+		{Action: "create", Actor: "key:alice", IP: "127.0.0.1", Request: "1", Target: g.Id(), After: before},
+		{Action: "move", Actor: "key:alice", IP: "127.0.0.1", Request: "2", Target: g.Id(), Before: before, After: g.MarshalRecord()},
+		{Action: "delete", Actor: "key:alice", IP: "127.0.0.1", Request: "3", Target: g.Id(), Before: g.MarshalRecord()},
+	} {
+		if err = l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fname
+}
+
+func TestAuditLog(t *testing.T) {
+	fname := newTestAuditLog(t)
+
+	// reopened log continues the chain
+	l, err := OpenAuditLog(fname, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &AuditEntry{Action: "ban", Actor: "admin-token", Target: "key:bob"}
+	if err = l.Append(e); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+	if e.Seq != 4 {
+		t.Errorf("got seq %d, want 4", e.Seq)
+	}
+
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, n, err := VerifyAuditLog(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 || last.Seq != 4 || last.Hash != e.Hash || last.Action != "ban" {
+		t.Errorf("got %d entries, the last one is %+v", n, last)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	data, err := ioutil.ReadFile(newTestAuditLog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")[:3]
+
+	tests := []struct {
+		name  string
+		lines []string
+		err   string
+	}{
+		{"changed actor", []string{lines[0], strings.Replace(lines[1], "key:alice", "key:bob", 1), lines[2]}, "entry 2: hash mismatch"},
+		{"removed entry", []string{lines[0], lines[2]}, "entry 2: sequence number 3 follows 1"},
+		{"removed first entry", []string{lines[1], lines[2]}, "entry 1: chain doesn't start"},
+		{"swapped entries", []string{lines[0], lines[2], lines[1]}, "entry 2: sequence number"},
+		{"no hash", []string{lines[0], `{"seq":2}` + "\n"}, "entry 2: no hash"},
+	}
+	for _, tt := range tests {
+		_, _, err := VerifyAuditLog(strings.NewReader(strings.Join(tt.lines, "")))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	if _, n, err := VerifyAuditLog(strings.NewReader("")); err != nil || n != 0 {
+		t.Errorf("empty log: got %d entries, error %v", n, err)
+	}
+}
+
+func TestOpenAuditLog_TornEntry(t *testing.T) {
+	fname := newTestAuditLog(t)
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// crash during write of the fourth entry
+	if err = ioutil.WriteFile(fname, append(data, `{"seq":4,"time":"2020-`...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenAuditLog(fname, testLogger())
+	if err != nil {
+		t.Fatalf("log with torn entry isn't opened: %s", err)
+	}
+	e := &AuditEntry{Action: "ban", Actor: "admin-token", Target: "key:bob"}
+	if err = l.Append(e); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+	if e.Seq != 4 {
+		t.Errorf("got seq %d, want 4", e.Seq)
+	}
+
+	repaired, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(repaired, data) {
+		t.Fatal("complete entries are changed")
+	}
+	if _, n, err := VerifyAuditLog(bytes.NewReader(repaired)); err != nil || n != 4 {
+		t.Errorf("got %d entries, error %v", n, err)
+	}
+
+	// the only line is torn
+	if err = ioutil.WriteFile(fname, []byte(`{"seq":1`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = OpenAuditLog(fname, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+	if info, _ := os.Stat(fname); info.Size() != 0 {
+		t.Errorf("got %d bytes, want empty log", info.Size())
+	}
+}
@@ -0,0 +1,155 @@
+package game
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	encMagic  = "TTE1" // encrypted game file prefix
+	keyIdSize = 4
+)
+
+// set of AES-GCM keys used to encrypt game files at rest.
+// The primary key encrypts, all keys decrypt, so keys could be rotated: add new primary key,
+// keep old ones until all games are saved again
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// create keyring from raw AES keys (16, 24 or 32 bytes), first key is the primary one
+func NewKeyring(keys [][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no storage keys")
+	}
+
+	k := &Keyring{keys: make(map[string]cipher.AEAD, len(keys))}
+	for idx, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		// key id is a part of key hash, it allows to pick the key without trying all of them
+		sum := sha256.Sum256(key)
+		id := string(sum[:keyIdSize])
+		k.keys[id] = aead
+		if idx == 0 {
+			k.primary = id
+		}
+	}
+	return k, nil
+}
+
+// load base64 encoded keys from file (one key per line, `#` starts comment) and from env value
+// (keys separated by commas). Keys from file go first. Returns nil keyring if there are no keys
+func LoadKeyring(fname string, env string) (*Keyring, error) {
+	var encoded []string
+
+	if fname != "" {
+		f, err := os.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			encoded = append(encoded, line)
+		}
+		if err = sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range strings.Split(env, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			encoded = append(encoded, s)
+		}
+	}
+
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	keys := make([][]byte, 0, len(encoded))
+	for _, s := range encoded {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.New("can't decode storage key: " + err.Error())
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(keys)
+}
+
+// generate new random base64 encoded AES-256 key
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// encrypt data with the primary key
+func (k *Keyring) Seal(data []byte) ([]byte, error) {
+	aead := k.keys[k.primary]
+
+	buf := make([]byte, 0, len(encMagic)+keyIdSize+aead.NonceSize()+len(data)+aead.Overhead())
+	buf = append(buf, encMagic...)
+	buf = append(buf, k.primary...)
+
+	nonce := buf[len(buf) : len(buf)+aead.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	buf = buf[:len(buf)+aead.NonceSize()]
+
+	// header is authenticated too
+	return aead.Seal(buf, nonce, data, buf[:len(encMagic)+keyIdSize]), nil
+}
+
+// decrypt data encrypted with any key from keyring
+func (k *Keyring) Open(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("data is not encrypted")
+	}
+
+	hdrSize := len(encMagic) + keyIdSize
+	id := string(data[len(encMagic):hdrSize])
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, errors.New("unknown storage key " + hex.EncodeToString([]byte(id)))
+	}
+
+	if len(data) < hdrSize+aead.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+	nonce := data[hdrSize : hdrSize+aead.NonceSize()]
+	return aead.Open(nil, nonce, data[hdrSize+aead.NonceSize():], data[:hdrSize])
+}
+
+// is data encrypted game file content
+func IsEncrypted(data []byte) bool {
+	return len(data) >= len(encMagic)+keyIdSize && bytes.HasPrefix(data, []byte(encMagic))
+}
@@ -1,15 +1,57 @@
 package game
 
+import (
+	"github.com/valyala/fasthttp"
+	"strings"
+)
+
+// machine-readable error codes, they are part of API and must not be changed
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeInvalidGameId  = "invalid_game_id"
+	CodeInvalidBoard   = "invalid_board"
+	CodeInvalidMove    = "invalid_move"
+	CodeGameFinished   = "game_finished"
+	CodeGameNotFound   = "game_not_found"
+	CodeGameExists     = "game_exists"
+	CodeGameRunning    = "game_running"
+
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeRequestInProgress    = "request_in_progress"
+
+	CodeUserExists         = "user_exists"
+	CodeUserNotFound       = "user_not_found"
+	CodeInvalidCredentials = "invalid_credentials"
+
+	CodeRateLimited  = "rate_limited"
+	CodeTooManyGames = "too_many_running_games"
+)
+
 // game error wrapper to save status code
 type GameError struct {
 	Status  int
 	message string
+	code    string
 }
 
 func (e *GameError) Error() string {
 	return e.message
 }
 
+// set machine-readable error code
+func (e *GameError) WithCode(code string) *GameError {
+	e.code = code
+	return e
+}
+
+// machine-readable error code. If it is not set, it is derived from status, e.g. `not_found`
+func (e *GameError) Code() string {
+	if e.code != "" {
+		return e.code
+	}
+	return strings.ReplaceAll(strings.ToLower(fasthttp.StatusMessage(e.Status)), " ", "_")
+}
+
 func NewGameError(status int, text string, err ...error) *GameError {
 	var errMsg string
 
@@ -22,3 +64,11 @@ func NewGameError(status int, text string, err ...error) *GameError {
 		message: text + errMsg,
 	}
 }
+
+// convert any error to GameError, unknown errors are internal ones
+func AsGameError(err error) *GameError {
+	if gErr, ok := err.(*GameError); ok {
+		return gErr
+	}
+	return NewGameError(fasthttp.StatusInternalServerError, "internal error", err)
+}
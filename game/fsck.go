@@ -0,0 +1,201 @@
+package game
+
+import (
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// kinds of storage problems found by fsck
+const (
+	FsckInvalidName    = "invalid-name"       // file name is not a game id
+	FsckOversized      = "oversized"          // file is larger than `maxFileSize`
+	FsckCorrupt        = "corrupt"            // file can't be decrypted, checksum mismatch or bad json
+	FsckNoChecksum     = "no-checksum"        // file was written before checksums were introduced
+	FsckIdMismatch     = "id-mismatch"        // game id in file doesn't match file name
+	FsckInconsistent   = "inconsistent-board" // board or status are not valid
+	FsckOrphanedBackup = "orphaned-backup"    // backup file without game file, save was interrupted
+	FsckStaleBackup    = "stale-backup"       // backup file, which was not removed after save
+)
+
+type FsckProblem struct {
+	Name        string // file name relative to storage dir
+	Kind        string
+	Message     string
+	Quarantined bool
+	Restored    bool // orphaned backup is renamed back to game file
+
+	restorable bool // orphaned backup contains valid game
+}
+
+type FsckReport struct {
+	Checked  int // number of checked files
+	Problems []FsckProblem
+}
+
+// scan storage dir and report broken files. If `quarantine` is set, broken files are moved
+// into quarantine dir inside the storage. Files without checksum are reported, but not moved.
+// Valid orphaned backup is the only copy of the game after crash, so it is restored instead
+func (s *StorageFile) Fsck(quarantine bool) (*FsckReport, error) {
+	s.rwm.RLock()
+	files, err := ioutil.ReadDir(s.path)
+	s.rwm.RUnlock()
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read storage dir", err)
+	}
+
+	report := &FsckReport{}
+	exists := make(map[string]bool, len(files))
+	for _, f := range files {
+		exists[f.Name()] = true
+	}
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() {
+			if strings.HasPrefix(name, ".") {
+				// service dirs
+				continue
+			}
+			report.add(FsckInvalidName, name, "unexpected dir")
+			continue
+		}
+		report.Checked++
+
+		if id := strings.TrimSuffix(name, backupExt); id != name && s.IsValidGameId(id) {
+			switch kind, msg := s.checkGameFile(p, name, id); {
+			case exists[id]:
+				report.add(FsckStaleBackup, name, "backup of existing game")
+			case f.Size() > maxFileSize:
+				report.add(FsckOrphanedBackup, name, "backup without game file exceeds maximum game file size")
+			case kind == "" || kind == FsckNoChecksum:
+				report.add(FsckOrphanedBackup, name, "backup without game file, it is the only copy of the game")
+				report.Problems[len(report.Problems)-1].restorable = true
+			default:
+				report.add(FsckOrphanedBackup, name, "backup without game file is broken: "+msg)
+			}
+			continue
+		}
+		if !s.IsValidGameId(name) {
+			report.add(FsckInvalidName, name, "file name is not a game id")
+			continue
+		}
+		if f.Size() > maxFileSize {
+			report.add(FsckOversized, name, "file size exceeds maximum game file size")
+			continue
+		}
+
+		kind, msg := s.checkGameFile(p, name, name)
+		if kind != "" {
+			report.add(kind, name, msg)
+		}
+	}
+
+	if quarantine {
+		for idx := range report.Problems {
+			pr := &report.Problems[idx]
+			if pr.Kind == FsckNoChecksum {
+				continue
+			}
+			if pr.restorable {
+				if err = s.restoreBackup(pr.Name); err != nil {
+					return report, err
+				}
+				pr.Restored = true
+				continue
+			}
+			if err = s.quarantine(pr.Name); err != nil {
+				return report, err
+			}
+			pr.Quarantined = true
+		}
+	}
+
+	return report, nil
+}
+
+func (r *FsckReport) add(kind, name, msg string) {
+	r.Problems = append(r.Problems, FsckProblem{Name: name, Kind: kind, Message: msg})
+}
+
+// check content of game file, which must contain game `id`. Problem kind and description are returned
+func (s *StorageFile) checkGameFile(p *fastjson.Parser, name, id string) (string, string) {
+	s.rwm.RLock()
+	data, err := ioutil.ReadFile(filepath.Join(s.path, name))
+	s.rwm.RUnlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			// removed during scan
+			return "", ""
+		}
+		return FsckCorrupt, err.Error()
+	}
+
+	if IsEncrypted(data) {
+		if s.keyring == nil {
+			return FsckCorrupt, "game file is encrypted, but storage key is not set"
+		}
+		if data, err = s.keyring.Open(data); err != nil {
+			return FsckCorrupt, "can't decrypt game file: " + err.Error()
+		}
+	}
+
+	content, found, err := verifyChecksum(data)
+	if err != nil {
+		return FsckCorrupt, err.Error()
+	}
+
+	g, err := Unmarshal(p, content)
+	if err != nil {
+		return FsckCorrupt, err.Error()
+	}
+	if g.id != id {
+		return FsckIdMismatch, "file contains game " + g.id
+	}
+	if err = g.Validate(); err != nil {
+		return FsckInconsistent, err.Error()
+	}
+
+	if !found {
+		return FsckNoChecksum, "game file has no checksum, it will be added on next save"
+	}
+	return "", ""
+}
+
+// rename orphaned backup back to game file, unless the game has been saved again meanwhile
+func (s *StorageFile) restoreBackup(name string) error {
+	fname := filepath.Join(s.path, strings.TrimSuffix(name, backupExt))
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	if _, err := os.Stat(fname); err == nil {
+		return nil
+	}
+	if err := os.Rename(filepath.Join(s.path, name), fname); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't restore game from backup", err)
+	}
+	return nil
+}
+
+// move file into quarantine dir
+func (s *StorageFile) quarantine(name string) error {
+	dir := filepath.Join(s.path, quarantineDir)
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't create quarantine dir", err)
+	}
+	if err := os.Rename(filepath.Join(s.path, name), filepath.Join(dir, name)); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't move file to quarantine", err)
+	}
+	return nil
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 )
 
+var arenaPool fastjson.ArenaPool // reuse arenas to marshal games
+
 const (
 	RUNNING = "RUNNING"
 	XWON    = "X_WON"
@@ -30,10 +32,36 @@ const (
 	UserSetX = 117
 )
 
+// computer player difficulty
+const (
+	DifficultyEasy = "easy" // random moves
+	DifficultyHard = "hard" // never loses
+)
+
+// players
+const (
+	PlayerUser     = "user"
+	PlayerComputer = "computer"
+)
+
+type Move struct {
+	Cell   int
+	Sign   byte
+	Player string
+	At     time.Time
+}
+
 type Game struct {
-	id     string
-	board  []byte
-	status string
+	id         string
+	board      []byte
+	status     string
+	created    time.Time
+	updated    time.Time
+	difficulty string
+	moves      []Move
+	owner      string // client which started the game, empty if authentication is disabled
+	creator    string // IP address of anonymous client which started the game
+	finishedBy string // admin which finished the game regardless of the board
 }
 
 func NewGame(board []byte, userSign byte) *Game {
@@ -44,11 +72,48 @@ func NewGame(board []byte, userSign byte) *Game {
 		firstLetter = "f"
 	}
 
-	return &Game{
-		id:     firstLetter + uuid.NewV4().String()[1:],
-		board:  board,
-		status: RUNNING,
+	now := time.Now().UTC()
+	g := &Game{
+		id:         firstLetter + uuid.NewV4().String()[1:],
+		board:      board,
+		status:     RUNNING,
+		created:    now,
+		updated:    now,
+		difficulty: DifficultyEasy,
+	}
+
+	// user could make the first move
+	for idx, b := range board {
+		if b != DashChar {
+			g.addMove(idx, b, PlayerUser)
+		}
+	}
+	return g
+}
+
+// set computer player difficulty
+func (g *Game) SetDifficulty(difficulty string) error {
+	switch difficulty {
+	case DifficultyEasy, DifficultyHard:
+		g.difficulty = difficulty
+		return nil
 	}
+	return NewGameError(fasthttp.StatusBadRequest, "unknown difficulty "+difficulty).WithCode(CodeInvalidRequest)
+}
+
+// set client which owns the game
+func (g *Game) SetOwner(owner string) {
+	g.owner = owner
+}
+
+// set IP address of anonymous client which starts the game
+func (g *Game) SetCreator(ip string) {
+	g.creator = ip
+}
+
+func (g *Game) addMove(cell int, sign byte, player string) {
+	g.updated = time.Now().UTC()
+	g.moves = append(g.moves, Move{Cell: cell, Sign: sign, Player: player, At: g.updated})
 }
 
 // get user sign
@@ -74,19 +139,129 @@ func (g *Game) MakeMove() {
 		compSign = OChar
 	}
 
+	if g.difficulty == DifficultyHard {
+		if cell := bestMove(g.board, compSign, g.UserSign()); cell >= 0 {
+			g.board[cell] = compSign
+			g.addMove(cell, compSign, PlayerComputer)
+		}
+		return
+	}
+
 	// AI :)
 	rand.Seed(time.Now().UnixNano())
 	for _, idx := range rand.Perm(9) {
 		if g.board[idx] == DashChar {
 			g.board[idx] = compSign
+			g.addMove(idx, compSign, PlayerComputer)
 			break
 		}
 	}
 }
 
-// create json string from Game struct
+// find the best move with minimax, returns -1 if there are no free cells
+func bestMove(board []byte, sign, opponent byte) int {
+	best, bestScore := -1, -2
+	for cell := 0; cell < 9; cell++ {
+		if board[cell] != DashChar {
+			continue
+		}
+		board[cell] = sign
+		score := -minimax(board, opponent, sign)
+		board[cell] = DashChar
+		if score > bestScore {
+			best, bestScore = cell, score
+		}
+	}
+	return best
+}
+
+// score of the board for player who moves: 1 - win, 0 - draw, -1 - loss
+func minimax(board []byte, sign, opponent byte) int {
+	if hasLine(board, opponent) {
+		return -1
+	}
+
+	best := -2
+	for cell := 0; cell < 9; cell++ {
+		if board[cell] != DashChar {
+			continue
+		}
+		board[cell] = sign
+		score := -minimax(board, opponent, sign)
+		board[cell] = DashChar
+		if score > best {
+			best = score
+		}
+	}
+	if best == -2 {
+		// no free cells
+		return 0
+	}
+	return best
+}
+
+func hasLine(board []byte, s byte) bool {
+	return winLine(board, s) != nil
+}
+
+// line which wins the game for the sign, nil if there is no such line
+func winLine(board []byte, s byte) []int {
+	for _, c := range winLines {
+		if board[c[0]] == s && board[c[1]] == s && board[c[2]] == s {
+			return c
+		}
+	}
+	return nil
+}
+
+// create json string from Game struct, it's v1 API game object
 func (g *Game) Marshal() []byte {
-	return []byte(`{"id":"` + g.id + `","board":"` + string(g.board) + `","status":"` + g.status + `"}`)
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("id", a.NewString(g.id))
+	o.Set("board", a.NewStringBytes(g.board))
+	o.Set("status", a.NewString(g.status))
+	return o.MarshalTo(nil)
+}
+
+// create json record with all game fields to be saved in storage
+func (g *Game) MarshalRecord() []byte {
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("id", a.NewString(g.id))
+	o.Set("board", a.NewStringBytes(g.board))
+	o.Set("status", a.NewString(g.status))
+	if !g.created.IsZero() {
+		o.Set("created", a.NewString(g.created.Format(time.RFC3339Nano)))
+		o.Set("updated", a.NewString(g.updated.Format(time.RFC3339Nano)))
+	}
+	o.Set("difficulty", a.NewString(g.difficulty))
+	if g.owner != "" {
+		o.Set("owner", a.NewString(g.owner))
+	}
+	if g.creator != "" {
+		o.Set("creator", a.NewString(g.creator))
+	}
+	if g.finishedBy != "" {
+		o.Set("finished_by", a.NewString(g.finishedBy))
+	}
+
+	moves := a.NewArray()
+	for idx, m := range g.moves {
+		mv := a.NewObject()
+		mv.Set("cell", a.NewNumberInt(m.Cell))
+		mv.Set("sign", a.NewString(string(m.Sign)))
+		mv.Set("player", a.NewString(m.Player))
+		mv.Set("at", a.NewString(m.At.Format(time.RFC3339Nano)))
+		moves.SetArrayItem(idx, mv)
+	}
+	o.Set("moves", moves)
+
+	return o.MarshalTo(nil)
 }
 
 func (g *Game) Id() string {
@@ -101,11 +276,50 @@ func (g *Game) Board() []byte {
 	return g.board
 }
 
+func (g *Game) Created() time.Time {
+	return g.created
+}
+
+func (g *Game) Updated() time.Time {
+	return g.updated
+}
+
+func (g *Game) Difficulty() string {
+	return g.difficulty
+}
+
+func (g *Game) Owner() string {
+	return g.owner
+}
+
+func (g *Game) Creator() string {
+	return g.creator
+}
+
+func (g *Game) FinishedBy() string {
+	return g.finishedBy
+}
+
+func (g *Game) Moves() []Move {
+	return g.moves
+}
+
+// line of cells which won the game, nil if nobody has won
+func (g *Game) WinningLine() []int {
+	switch g.status {
+	case XWON:
+		return winLine(g.board, XChar)
+	case OWON:
+		return winLine(g.board, OChar)
+	}
+	return nil
+}
+
 // compare previous board with new one and validate user move
 func (g *Game) SetNewBoard(newBoard []byte) (bool, error) {
 	sum := 0
 	if len(newBoard) != 9 {
-		return false, NewGameError(fasthttp.StatusBadRequest, "invalid board length")
+		return false, NewGameError(fasthttp.StatusBadRequest, "invalid board length").WithCode(CodeInvalidBoard)
 	}
 	for i := 0; i < 9; i++ {
 		// oldBoard is always valid
@@ -117,12 +331,17 @@ func (g *Game) SetNewBoard(newBoard []byte) (bool, error) {
 			sum += int(g.board[i] ^ newBoard[i])
 		default:
 			// board contains invalid chars
-			return false, NewGameError(fasthttp.StatusBadRequest, "board contains invalid chars")
+			return false, NewGameError(fasthttp.StatusBadRequest, "board contains invalid chars").WithCode(CodeInvalidBoard)
 		}
 	}
 
 	// check is user has made correct move
 	if (sum == UserSetX && g.UserSign() == XChar) || (sum == UserSetO && g.UserSign() == OChar) {
+		for i := 0; i < 9; i++ {
+			if g.board[i] != newBoard[i] {
+				g.addMove(i, newBoard[i], PlayerUser)
+			}
+		}
 		// update board
 		g.board = newBoard
 
@@ -130,24 +349,59 @@ func (g *Game) SetNewBoard(newBoard []byte) (bool, error) {
 	}
 
 	// board sign didn't match user's first move. strange
-	return false, NewGameError(fasthttp.StatusBadRequest, "move not valid")
+	return false, NewGameError(fasthttp.StatusBadRequest, "move not valid").WithCode(CodeInvalidMove)
 }
 
-// check winner
-func (g *Game) CheckWin(s byte) string {
-	coords := [][]int{
-		{0, 4, 8},
-		{2, 4, 6},
-		{0, 1, 2},
-		{3, 4, 5},
-		{6, 7, 8},
-		{0, 3, 6},
-		{1, 4, 7},
-		{2, 5, 8},
+// all lines which win the game
+var winLines = [][]int{
+	{0, 4, 8},
+	{2, 4, 6},
+	{0, 1, 2},
+	{3, 4, 5},
+	{6, 7, 8},
+	{0, 3, 6},
+	{1, 4, 7},
+	{2, 5, 8},
+}
+
+// put user's sign into the cell, cells are numbered 0-8 row by row
+func (g *Game) PlaceSign(cell int) error {
+	if cell < 0 || cell > 8 {
+		return NewGameError(fasthttp.StatusBadRequest, "cell must be in range 0-8").WithCode(CodeInvalidMove)
+	}
+	if g.board[cell] != DashChar {
+		return NewGameError(fasthttp.StatusBadRequest, "cell is already taken").WithCode(CodeInvalidMove)
+	}
+
+	g.board[cell] = g.UserSign()
+	g.addMove(cell, g.UserSign(), PlayerUser)
+	return nil
+}
+
+// finish running game with given result regardless of the board, e.g. when it is abandoned. The
+// game is marked as finished by admin, so its status isn't checked against the board
+func (g *Game) Finish(status, by string) error {
+	if g.status != RUNNING {
+		return NewGameError(fasthttp.StatusBadRequest, "game already finished with status "+g.status).WithCode(CodeGameFinished)
 	}
+	switch status {
+	case XWON, OWON, DRAW:
+	default:
+		return NewGameError(fasthttp.StatusBadRequest, "game could be finished with X_WON, O_WON or DRAW only").WithCode(CodeInvalidRequest)
+	}
+	if by == "" {
+		return NewGameError(fasthttp.StatusBadRequest, "game must be finished by admin").WithCode(CodeInvalidRequest)
+	}
+	g.status = status
+	g.finishedBy = by
+	g.updated = time.Now().UTC()
+	return nil
+}
 
+// check winner
+func (g *Game) CheckWin(s byte) string {
 	// check WIN position
-	for _, c := range coords {
+	for _, c := range winLines {
 		if g.board[c[0]] == s && g.board[c[1]] == s && g.board[c[2]] == s {
 			if s == XChar {
 				g.status = XWON
@@ -176,6 +430,58 @@ func (g *Game) CheckWin(s byte) string {
 	return RUNNING
 }
 
+// check that stored game is consistent: board contains only valid chars, players made
+// right number of moves and status matches the board
+func (g *Game) Validate() error {
+	if len(g.id) == 0 || (g.id[0] != 'a' && g.id[0] != 'f') {
+		return NewGameError(fasthttp.StatusInternalServerError, "invalid game id")
+	}
+	if len(g.board) != 9 {
+		return NewGameError(fasthttp.StatusInternalServerError, "invalid board length")
+	}
+
+	var xNum, oNum int
+	hasDash := false
+	for _, b := range g.board {
+		switch b {
+		case XChar:
+			xNum++
+		case OChar:
+			oNum++
+		case DashChar:
+			hasDash = true
+		default:
+			return NewGameError(fasthttp.StatusInternalServerError, "board contains invalid chars")
+		}
+	}
+	if xNum-oNum > 1 || oNum-xNum > 1 {
+		return NewGameError(fasthttp.StatusInternalServerError, "invalid number of moves")
+	}
+
+	xWon, oWon := hasLine(g.board, XChar), hasLine(g.board, OChar)
+
+	var valid bool
+	switch {
+	case g.finishedBy != "":
+		// admin finishes game with any result, e.g. abandoned one
+		valid = g.status == XWON || g.status == OWON || g.status == DRAW
+	case g.status == RUNNING:
+		valid = !xWon && !oWon && hasDash
+	case g.status == XWON:
+		valid = xWon && !oWon
+	case g.status == OWON:
+		valid = oWon && !xWon
+	case g.status == DRAW:
+		valid = !xWon && !oWon && !hasDash
+	default:
+		return NewGameError(fasthttp.StatusInternalServerError, "unknown game status "+g.status)
+	}
+	if !valid {
+		return NewGameError(fasthttp.StatusInternalServerError, "game status "+g.status+" doesn't match the board")
+	}
+	return nil
+}
+
 // parse game file to Game struct
 func Unmarshal(p *fastjson.Parser, buf []byte) (*Game, error) {
 	val, err := p.ParseBytes(buf)
@@ -183,11 +489,44 @@ func Unmarshal(p *fastjson.Parser, buf []byte) (*Game, error) {
 		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't parse game file", err)
 	}
 
-	return &Game{
-		id:     string(val.GetStringBytes("id")),
-		board:  val.GetStringBytes("board"),
-		status: string(val.GetStringBytes("status")),
-	}, nil
+	// copy board, parsed value is valid until parser is reused
+	g := &Game{
+		id:         string(val.GetStringBytes("id")),
+		board:      append([]byte(nil), val.GetStringBytes("board")...),
+		status:     string(val.GetStringBytes("status")),
+		difficulty: string(val.GetStringBytes("difficulty")),
+		owner:      string(val.GetStringBytes("owner")),
+		creator:    string(val.GetStringBytes("creator")),
+		finishedBy: string(val.GetStringBytes("finished_by")),
+	}
+
+	// games saved before v2 API have no timestamps, difficulty and moves
+	if g.difficulty == "" {
+		g.difficulty = DifficultyEasy
+	}
+	if val.Exists("created") {
+		if g.created, err = time.Parse(time.RFC3339Nano, string(val.GetStringBytes("created"))); err != nil {
+			return nil, NewGameError(fasthttp.StatusInternalServerError, "can't parse game creation time", err)
+		}
+		if g.updated, err = time.Parse(time.RFC3339Nano, string(val.GetStringBytes("updated"))); err != nil {
+			return nil, NewGameError(fasthttp.StatusInternalServerError, "can't parse game update time", err)
+		}
+	}
+	for _, mv := range val.GetArray("moves") {
+		sign := mv.GetStringBytes("sign")
+		at, err := time.Parse(time.RFC3339Nano, string(mv.GetStringBytes("at")))
+		if len(sign) != 1 || err != nil {
+			return nil, NewGameError(fasthttp.StatusInternalServerError, "can't parse game move")
+		}
+		g.moves = append(g.moves, Move{
+			Cell:   mv.GetInt("cell"),
+			Sign:   sign[0],
+			Player: string(mv.GetStringBytes("player")),
+			At:     at,
+		})
+	}
+
+	return g, nil
 
 }
 
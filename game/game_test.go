@@ -1,6 +1,9 @@
 package game
 
-import "testing"
+import (
+	"github.com/valyala/fastjson"
+	"testing"
+)
 
 type boards struct {
 	oldBoard string
@@ -180,3 +183,132 @@ func TestCheckWin(t *testing.T) {
 		}
 	}
 }
+
+func TestGame_Validate(t *testing.T) {
+	valid := []Game{
+		{id: "a", board: []byte("X---O----"), status: RUNNING},
+		{id: "f", board: []byte("----X----"), status: RUNNING},
+		{id: "a", board: []byte("XXXOO----"), status: XWON},
+		{id: "f", board: []byte("OOOXX-X--"), status: OWON},
+		{id: "a", board: []byte("XXOOOXXOX"), status: DRAW},
+	}
+	invalid := []Game{
+		{id: "", board: []byte("X---O----"), status: RUNNING},
+		{id: "a", board: []byte("X---O---"), status: RUNNING},
+		{id: "a", board: []byte("X---o----"), status: RUNNING},
+		{id: "a", board: []byte("XX-------"), status: RUNNING},
+		{id: "a", board: []byte("XXXOO----"), status: RUNNING},
+		{id: "a", board: []byte("XXXOOO---"), status: XWON},
+		{id: "a", board: []byte("X---O----"), status: DRAW},
+		{id: "a", board: []byte("XXOOOXXOX"), status: OWON},
+		{id: "a", board: []byte("X---O----"), status: "LOST"},
+	}
+
+	for idx, g := range valid {
+		if err := g.Validate(); err != nil {
+			t.Fatalf("valid game (%d) %s is invalid: %s", idx, g.Marshal(), err)
+		}
+	}
+	for idx, g := range invalid {
+		if err := g.Validate(); err == nil {
+			t.Fatalf("invalid game (%d) %s is valid", idx, g.Marshal())
+		}
+	}
+}
+
+func TestGame_PlaceSign(t *testing.T) {
+	g := &Game{id: "a", board: []byte("----O----"), status: RUNNING}
+
+	for _, cell := range []int{-1, 4, 9} {
+		if err := g.PlaceSign(cell); err == nil {
+			t.Fatalf("invalid cell %d accepted", cell)
+		}
+	}
+	if err := g.PlaceSign(8); err != nil {
+		t.Fatalf("valid cell rejected: %s", err)
+	}
+	if string(g.Board()) != "----O---X" {
+		t.Fatalf("unexpected board %s", g.Board())
+	}
+}
+
+func TestGame_MakeMoveHard(t *testing.T) {
+	// computer plays O and must block the X line
+	g := NewGame([]byte("XX--O----"), XChar)
+	if err := g.SetDifficulty(DifficultyHard); err != nil {
+		t.Fatal(err)
+	}
+	g.MakeMove()
+	if string(g.Board()) != "XXO-O----" {
+		t.Fatalf("line is not blocked: %s", g.Board())
+	}
+	if m := g.Moves()[len(g.Moves())-1]; m.Cell != 2 || m.Player != PlayerComputer {
+		t.Fatalf("unexpected last move %+v", m)
+	}
+
+	if err := g.SetDifficulty("impossible"); err == nil {
+		t.Fatal("unknown difficulty accepted")
+	}
+}
+
+func TestGame_WinningLine(t *testing.T) {
+	g := &Game{id: "a", board: []byte("XXXOO----"), status: RUNNING}
+	if g.WinningLine() != nil {
+		t.Fatal("winning line of running game")
+	}
+	g.CheckWin(XChar)
+	if line := g.WinningLine(); len(line) != 3 || line[0] != 0 || line[2] != 2 {
+		t.Fatalf("unexpected winning line %v", line)
+	}
+}
+
+func TestGame_Finish(t *testing.T) {
+	g := NewGame([]byte("X---O----"), XChar)
+	if err := g.Finish(RUNNING, "admin-token"); err == nil {
+		t.Fatal("game is finished as running")
+	}
+	if err := g.Finish(DRAW, ""); err == nil {
+		t.Fatal("game is finished by nobody")
+	}
+	if err := g.Finish(DRAW, "admin-token"); err != nil {
+		t.Fatal(err)
+	}
+	if g.Status() != DRAW || g.FinishedBy() != "admin-token" {
+		t.Fatalf("unexpected status %s finished by %q", g.Status(), g.FinishedBy())
+	}
+	// status doesn't match the board, but game is finished by admin
+	if err := g.Validate(); err != nil {
+		t.Fatalf("game finished by admin is invalid: %s", err)
+	}
+	res, err := Unmarshal(new(fastjson.Parser), g.MarshalRecord())
+	if err != nil || res.FinishedBy() != "admin-token" || res.Validate() != nil {
+		t.Fatalf("finished by is lost: %v", err)
+	}
+	if err := g.Finish(XWON, "admin-token"); err == nil {
+		t.Fatal("finished game is finished again")
+	}
+}
+
+func TestGame_Marshal(t *testing.T) {
+	g := &Game{id: "a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e", board: []byte("X---O----"), status: RUNNING, difficulty: DifficultyHard}
+
+	// v1 game object must keep its shape
+	expected := `{"id":"a2b5ce6e-2c60-4f5a-9b6d-7c8b4b4b1a1e","board":"X---O----","status":"RUNNING"}`
+	if res := string(g.Marshal()); res != expected {
+		t.Fatalf("expected %s, got %s", expected, res)
+	}
+}
+
+func TestGame_MarshalRecord(t *testing.T) {
+	g := NewGame([]byte("X---O----"), XChar)
+	g.SetOwner("key:alice")
+	g.SetCreator("10.0.0.1")
+
+	res, err := Unmarshal(new(fastjson.Parser), g.MarshalRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Owner() != "key:alice" || res.Creator() != "10.0.0.1" {
+		t.Fatalf("owner and creator are not kept: %q, %q", res.Owner(), res.Creator())
+	}
+}
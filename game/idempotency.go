@@ -0,0 +1,57 @@
+package game
+
+import (
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"time"
+)
+
+// response saved for `Idempotency-Key`, repeated request with the same key gets it back
+type IdempotentResponse struct {
+	Key         string
+	Fingerprint string // hash of the original request, the key can't be reused for another request
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	Created     time.Time
+}
+
+// create json record to be saved in storage
+func (r *IdempotentResponse) Marshal() []byte {
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("key", a.NewString(r.Key))
+	o.Set("fingerprint", a.NewString(r.Fingerprint))
+	o.Set("status", a.NewNumberInt(r.Status))
+	o.Set("content_type", a.NewString(r.ContentType))
+	o.Set("location", a.NewString(r.Location))
+	o.Set("body", a.NewStringBytes(r.Body))
+	o.Set("created", a.NewString(r.Created.UTC().Format(time.RFC3339Nano)))
+	return o.MarshalTo(nil)
+}
+
+func UnmarshalIdempotentResponse(p *fastjson.Parser, buf []byte) (*IdempotentResponse, error) {
+	v, err := p.ParseBytes(buf)
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't parse saved response", err)
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, string(v.GetStringBytes("created")))
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "invalid saved response time", err)
+	}
+
+	// copy values, they are valid until parser is reused
+	return &IdempotentResponse{
+		Key:         string(v.GetStringBytes("key")),
+		Fingerprint: string(v.GetStringBytes("fingerprint")),
+		Status:      v.GetInt("status"),
+		ContentType: string(v.GetStringBytes("content_type")),
+		Location:    string(v.GetStringBytes("location")),
+		Body:        append([]byte(nil), v.GetStringBytes("body")...),
+		Created:     created,
+	}, nil
+}
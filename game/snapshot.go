@@ -0,0 +1,292 @@
+package game
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type snapshotFile struct {
+	name    string
+	modTime time.Time
+	content []byte
+}
+
+// storage dir included into snapshot besides games. Its files are `<dir>/<name>` entries of
+// archive, they are validated by decoded content before restore
+type snapshotDir struct {
+	name     string
+	limit    int64
+	validate func(s *StorageFile, p *fastjson.Parser, name string, content []byte) bool
+}
+
+var snapshotDirs = []*snapshotDir{
+	{name: trashDir, limit: maxFileSize, validate: validGameFile},
+	{name: archiveDir, limit: maxFileSize, validate: validGameFile},
+	{name: usersDir, limit: maxFileSize, validate: func(s *StorageFile, p *fastjson.Parser, name string, content []byte) bool {
+		u, err := UnmarshalUser(p, content)
+		return err == nil && u.Id == name
+	}},
+	{name: userNamesDir, limit: maxFileSize, validate: func(s *StorageFile, p *fastjson.Parser, name string, content []byte) bool {
+		return isHashName(name) && s.IsValidGameId(string(content))
+	}},
+	{name: sessionsDir, limit: maxFileSize, validate: func(s *StorageFile, p *fastjson.Parser, name string, content []byte) bool {
+		session, err := UnmarshalSession(p, content)
+		return err == nil && session.TokenHash == name
+	}},
+	{name: idempotencyDir, limit: maxResponseFileSize, validate: func(s *StorageFile, p *fastjson.Parser, name string, content []byte) bool {
+		r, err := UnmarshalIdempotentResponse(p, content)
+		return err == nil && filepath.Base(s.responseFile(r.Key)) == name
+	}},
+}
+
+func validGameFile(s *StorageFile, p *fastjson.Parser, name string, content []byte) bool {
+	g, err := Unmarshal(p, content)
+	return err == nil && g.id == name
+}
+
+// files named by sha256 hex
+func isHashName(name string) bool {
+	_, err := hex.DecodeString(name)
+	return err == nil && len(name) == 2*sha256.Size
+}
+
+// write consistent point-in-time tar.gz snapshot of all game files, trash, archive, users, sessions and
+// saved idempotent responses.
+// Game files are read into memory under the read lock, so writers are blocked only while
+// files are being read, not while the archive is compressed and sent to `w`.
+func (s *StorageFile) Snapshot(w io.Writer) error {
+	files, err := s.snapshotFiles()
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0640,
+			Size:    int64(len(f.content)),
+			ModTime: f.modTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return NewGameError(fasthttp.StatusInternalServerError, "can't write snapshot header", err)
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return NewGameError(fasthttp.StatusInternalServerError, "can't write snapshot file", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't close snapshot archive", err)
+	}
+	if err := gz.Close(); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't close snapshot compressor", err)
+	}
+	return nil
+}
+
+// read all game files and files of snapshot dirs under the read lock
+func (s *StorageFile) snapshotFiles() ([]snapshotFile, error) {
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+
+	entries, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read storage dir", err)
+	}
+
+	// Save() renames game file to backup before writing the new one, so a game could be
+	// represented by its backup only. Prefer the game file, fall back to the backup.
+	names := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		if s.IsValidGameId(name) {
+			names[name] = name
+		} else if id := strings.TrimSuffix(name, backupExt); id != name && s.IsValidGameId(id) {
+			if _, ok := names[id]; !ok {
+				names[id] = name
+			}
+		}
+	}
+
+	res := make([]snapshotFile, 0, len(names))
+	for id, name := range names {
+		f, err := s.readSnapshotFile(id, filepath.Join(s.path, name), maxFileSize)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			res = append(res, *f)
+		}
+	}
+
+	for _, dir := range snapshotDirs {
+		entries, err := ioutil.ReadDir(filepath.Join(s.path, dir.name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read storage dir", err)
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			name := dir.name + "/" + e.Name()
+			f, err := s.readSnapshotFile(name, filepath.Join(s.path, name), dir.limit)
+			if err != nil {
+				return nil, err
+			}
+			if f != nil {
+				res = append(res, *f)
+			}
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res, nil
+}
+
+// read file to be archived as `name`, nil is returned if it has been removed by another process.
+// Snapshot fails if file is larger than limit
+func (s *StorageFile) readSnapshotFile(name, fname string, limit int64) (*snapshotFile, error) {
+	fInfo, err := os.Stat(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "error while checking file", err)
+	}
+	// snapshot without the file would lose it silently, and restore rejects such file anyway
+	if fInfo.Size() > limit {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, name+": file too large for snapshot")
+	}
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read file content", err)
+	}
+	return &snapshotFile{name: name, modTime: fInfo.ModTime(), content: content}, nil
+}
+
+// restore storage from tar.gz snapshot. Every file is validated before the storage
+// directory is swapped, previous storage content is kept in `<path>.pre-restore-<time>` dir
+func (s *StorageFile) RestoreSnapshot(r io.Reader) error {
+	path := filepath.Clean(s.path)
+	staging, err := ioutil.TempDir(filepath.Dir(path), filepath.Base(path)+".restore-")
+	if err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't create restore dir", err)
+	}
+
+	if err = s.extractSnapshot(r, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+	if err = os.Chmod(staging, 0750); err != nil {
+		_ = os.RemoveAll(staging)
+		return NewGameError(fasthttp.StatusInternalServerError, "can't set restore dir permissions", err)
+	}
+
+	old := path + ".pre-restore-" + time.Now().Format("20060102150405")
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	if err = os.Rename(path, old); err != nil {
+		_ = os.RemoveAll(staging)
+		return NewGameError(fasthttp.StatusInternalServerError, "can't move current storage away", err)
+	}
+	if err = os.Rename(staging, path); err != nil {
+		// try to roll back
+		if rbErr := os.Rename(old, path); rbErr != nil {
+			s.log.Errorf("can't roll back storage from %s: %s", old, rbErr)
+		}
+		return NewGameError(fasthttp.StatusInternalServerError, "can't swap storage dir", err)
+	}
+
+	s.log.Printf("storage restored, previous content moved to %s", old)
+	return nil
+}
+
+// extract and validate snapshot files into dir
+func (s *StorageFile) extractSnapshot(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return NewGameError(fasthttp.StatusBadRequest, "can't open snapshot", err)
+	}
+	defer gz.Close()
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return NewGameError(fasthttp.StatusBadRequest, "can't read snapshot", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return NewGameError(fasthttp.StatusBadRequest, "unexpected snapshot entry "+hdr.Name)
+		}
+
+		// game files are in root of archive, other files are in snapshot dirs
+		sd := &snapshotDir{limit: maxFileSize, validate: validGameFile}
+		name := hdr.Name
+		if idx := strings.IndexByte(hdr.Name, '/'); idx >= 0 {
+			sd, name = nil, hdr.Name[idx+1:]
+			for _, d := range snapshotDirs {
+				if d.name == hdr.Name[:idx] {
+					sd = d
+				}
+			}
+			if sd == nil || name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+				return NewGameError(fasthttp.StatusBadRequest, "unexpected snapshot entry "+hdr.Name)
+			}
+			if err = os.MkdirAll(filepath.Join(dir, sd.name), 0750); err != nil {
+				return NewGameError(fasthttp.StatusInternalServerError, "can't create restored dir", err)
+			}
+		} else if !s.IsValidGameId(name) {
+			return NewGameError(fasthttp.StatusBadRequest, "invalid game id in snapshot: "+hdr.Name)
+		}
+		if hdr.Size > sd.limit {
+			return NewGameError(fasthttp.StatusBadRequest, "file too large in snapshot: "+hdr.Name)
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(tr, sd.limit))
+		if err != nil {
+			return NewGameError(fasthttp.StatusBadRequest, "can't read snapshot file "+hdr.Name, err)
+		}
+		content, err := s.decode(data)
+		if err != nil {
+			return NewGameError(fasthttp.StatusBadRequest, "can't decode snapshot file "+hdr.Name, err)
+		}
+		if !sd.validate(s, p, name, content) {
+			return NewGameError(fasthttp.StatusBadRequest, "invalid file in snapshot "+hdr.Name)
+		}
+
+		fname := filepath.Join(dir, hdr.Name)
+		if err = ioutil.WriteFile(fname, data, 0640); err != nil {
+			return NewGameError(fasthttp.StatusInternalServerError, "can't write restored file", err)
+		}
+		_ = os.Chtimes(fname, hdr.ModTime, hdr.ModTime)
+	}
+}
@@ -1,27 +1,50 @@
 package game
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fastjson"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
 	"regexp"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 const (
-	maxFileSize = 512    // maximum file size for storage one game
-	backupExt   = ".bak" // backup file extenstion
+	// v2 game keeps history of moves with timestamps, owner, creator and admin which finished it:
+	// finished game of 40 chars subjects takes 1.1KB when encrypted. The rest is left for long JWT
+	// and client certificate subjects
+	maxFileSize         = 4096           // maximum file size for storage one game
+	maxResponseFileSize = 16384          // maximum file size of saved idempotent response
+	backupExt           = ".bak"         // backup file extenstion
+	checksumPrefix      = "\ncrc32c:"    // game content checksum trailer
+	quarantineDir       = ".quarantine"  // dir for broken files moved away by fsck
+	trashDir            = ".trash"       // dir for deleted games
+	archiveDir          = ".archive"     // dir for archived finished games
+	idempotencyDir      = ".idempotency" // dir for responses saved for idempotency keys
+	usersDir            = ".users"       // dir for registered users
+	userNamesDir        = ".usernames"   // dir for user ids indexed by name
+	sessionsDir         = ".sessions"    // dir for login sessions
 )
 
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 type StorageFile struct {
 	path          string
 	gameIdPattern *regexp.Regexp
 	log           *log.Logger
 	rwm           sync.RWMutex
 	parserPool    *fastjson.ParserPool
+	keyring       *Keyring // encrypt game files if set
+	lock          *os.File // storage dir locked by Lock
 }
 
 func NewStorage(path string, logger *log.Logger) (*StorageFile, error) {
@@ -52,27 +75,118 @@ func NewStorage(path string, logger *log.Logger) (*StorageFile, error) {
 	}, nil
 }
 
+// enable encryption at rest. Games are encrypted with primary key on next save,
+// plaintext game files remain readable
+func (s *StorageFile) SetKeyring(k *Keyring) {
+	s.keyring = k
+}
+
+// lock storage dir for exclusive use, e.g. by running service, so offline commands refuse to change
+// it. Lock is released by Unlock or when process exits
+func (s *StorageFile) Lock() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't open storage dir", err)
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return NewGameError(fasthttp.StatusConflict, "storage is used by another process, stop the service first")
+		}
+		return NewGameError(fasthttp.StatusInternalServerError, "can't lock storage dir", err)
+	}
+	s.lock = f
+	return nil
+}
+
+func (s *StorageFile) Unlock() error {
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Close()
+	s.lock = nil
+	return err
+}
+
+// add checksum trailer to game content
+func addChecksum(content []byte) []byte {
+	return append(content, fmt.Sprintf("%s%08x", checksumPrefix, crc32.Checksum(content, crc32cTable))...)
+}
+
+// verify and strip checksum trailer. Game files written before checksums were introduced have no
+// trailer, `found` is false for them
+func verifyChecksum(data []byte) (content []byte, found bool, err error) {
+	idx := bytes.LastIndex(data, []byte(checksumPrefix))
+	if idx < 0 {
+		return data, false, nil
+	}
+
+	content = data[:idx]
+	sum := fmt.Sprintf("%08x", crc32.Checksum(content, crc32cTable))
+	if string(data[idx+len(checksumPrefix):]) != sum {
+		return nil, true, NewGameError(fasthttp.StatusInternalServerError, "game file checksum mismatch")
+	}
+	return content, true, nil
+}
+
+// prepare game content to be written to file
+func (s *StorageFile) encode(content []byte) ([]byte, error) {
+	buf := addChecksum(content)
+	if s.keyring == nil {
+		return buf, nil
+	}
+	buf, err := s.keyring.Seal(buf)
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't encrypt game", err)
+	}
+	return buf, nil
+}
+
+// get game content from file data
+func (s *StorageFile) decode(data []byte) ([]byte, error) {
+	if IsEncrypted(data) {
+		if s.keyring == nil {
+			return nil, NewGameError(fasthttp.StatusInternalServerError, "game file is encrypted, but storage key is not set")
+		}
+		var err error
+		data, err = s.keyring.Open(data)
+		if err != nil {
+			return nil, NewGameError(fasthttp.StatusInternalServerError, "can't decrypt game file", err)
+		}
+	}
+
+	content, _, err := verifyChecksum(data)
+	return content, err
+}
+
 func (s *StorageFile) GetRaw(gameId string) ([]byte, error) {
-	fname := s.path + "/" + gameId
 	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	return s.readFile(s.path + "/" + gameId)
+}
+
+// read and decode game file. Caller must hold the lock
+func (s *StorageFile) readFile(fname string) ([]byte, error) {
+	return s.readFileLimit(fname, maxFileSize)
+}
+
+func (s *StorageFile) readFileLimit(fname string, limit int64) ([]byte, error) {
 	if fInfo, err := os.Stat(fname); err == nil {
-		// check if file size more than `maxFileSize`. It could prevent DoS via reading large files
-		if fInfo.Size() > maxFileSize {
+		// check if file size more than limit. It could prevent DoS via reading large files
+		if fInfo.Size() > limit {
 			return nil, NewGameError(fasthttp.StatusInternalServerError, "game file too large")
 		}
 	} else if os.IsNotExist(err) {
-		return nil, NewGameError(fasthttp.StatusNotFound, "game not exists", err)
+		return nil, NewGameError(fasthttp.StatusNotFound, "game not exists").WithCode(CodeGameNotFound)
 	} else {
 		return nil, NewGameError(fasthttp.StatusInternalServerError, "error while checking file", err)
 	}
 
-	content, err := ioutil.ReadFile(fname)
-	s.rwm.RUnlock()
-
+	data, err := ioutil.ReadFile(fname)
 	if err != nil {
 		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read file content", err)
 	}
-	return content, nil
+	return s.decode(data)
 }
 
 func (s *StorageFile) Get(gameId string) (*Game, error) {
@@ -111,11 +225,14 @@ func (s *StorageFile) Save(game *Game) error {
 		removeBackup = true
 	}
 
-	buf := game.Marshal()
+	buf, err := s.encode(game.MarshalRecord())
+	if err != nil {
+		return err
+	}
 
 	/* save new game file */
 	s.rwm.Lock()
-	err := ioutil.WriteFile(fname, buf, 0640)
+	err = ioutil.WriteFile(fname, buf, 0640)
 	s.rwm.Unlock()
 	if err != nil {
 		return NewGameError(fasthttp.StatusInternalServerError, "can't write game file", err)
@@ -143,13 +260,21 @@ func (s *StorageFile) List() ([]*Game, error) {
 	}
 	res := make([]*Game, 0, len(files))
 	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
 		if !s.IsValidGameId(f.Name()) {
-			s.log.Printf("invalid game id (%s) detected in storage. Remove it manually", f.Name())
+			s.log.Printf("invalid game id (%s) detected in storage. Run fsck to check storage", f.Name())
 			continue
 		}
 		game, err := s.Get(f.Name())
 		if err != nil {
-			return nil, err
+			if isNotFound(err) {
+				// removed while listing
+				continue
+			}
+			s.log.Errorf("game %s is broken and skipped: %s. Run fsck to check storage", f.Name(), err)
+			continue
 		}
 		res = append(res, game)
 	}
@@ -165,13 +290,21 @@ func (s *StorageFile) ListRaw() ([][]byte, error) {
 	}
 	res := make([][]byte, 0, len(files))
 	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
 		if !s.IsValidGameId(f.Name()) {
-			s.log.Printf("invalid game id (%s) detected in storage. Remove it manually", f.Name())
+			s.log.Printf("invalid game id (%s) detected in storage. Run fsck to check storage", f.Name())
 			continue
 		}
 		game, err := s.GetRaw(f.Name())
 		if err != nil {
-			return nil, err
+			if isNotFound(err) {
+				// removed while listing
+				continue
+			}
+			s.log.Errorf("game %s is broken and skipped: %s. Run fsck to check storage", f.Name(), err)
+			continue
 		}
 		res = append(res, game)
 	}
@@ -196,25 +329,576 @@ func (s *StorageFile) IsGameExists(gameId string) (bool, error) {
 
 func (s *StorageFile) Delete(gameId string) error {
 	if ok, _ := s.IsGameExists(gameId); !ok {
-		return NewGameError(fasthttp.StatusNotFound, "game not found")
+		return NewGameError(fasthttp.StatusNotFound, "game not found").WithCode(CodeGameNotFound)
 	}
 
 	// double check, just in case
 	if !s.IsValidGameId(gameId) {
-		return NewGameError(fasthttp.StatusBadRequest, "invalid game id")
+		return NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(CodeInvalidGameId)
 	}
 
-	fname := s.path + "/" + gameId
+	// move game to trash, modification time of trashed file is the deletion time
 	s.rwm.Lock()
-	err := os.Remove(fname)
-	s.rwm.Unlock()
+	defer s.rwm.Unlock()
+
+	if err := os.MkdirAll(s.path+"/"+trashDir, 0750); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't create trash dir", err)
+	}
+	fname := s.path + "/" + trashDir + "/" + gameId
+	if err := os.Rename(s.path+"/"+gameId, fname); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't move game file to trash", err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(fname, now, now); err != nil {
+		s.log.Printf("game %s: can't set deletion time: %s", gameId, err)
+	}
+
+	return nil
+}
+
+func (s *StorageFile) ListDeleted() ([]*DeletedGame, error) {
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+
+	files, err := ioutil.ReadDir(s.path + "/" + trashDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*DeletedGame{}, nil
+		}
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read trash dir", err)
+	}
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	res := make([]*DeletedGame, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !s.IsValidGameId(f.Name()) {
+			continue
+		}
+		content, err := s.readFile(s.path + "/" + trashDir + "/" + f.Name())
+		if err != nil {
+			s.log.Errorf("deleted game %s is broken and skipped: %s", f.Name(), err)
+			continue
+		}
+		game, err := Unmarshal(p, content)
+		if err != nil {
+			s.log.Errorf("deleted game %s is broken and skipped: %s", f.Name(), err)
+			continue
+		}
+		res = append(res, &DeletedGame{Game: game, DeletedAt: f.ModTime()})
+	}
+	return res, nil
+}
+
+func (s *StorageFile) GetDeleted(gameId string) (*DeletedGame, error) {
+	if !s.IsValidGameId(gameId) {
+		return nil, NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(CodeInvalidGameId)
+	}
+
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+
+	fname := s.path + "/" + trashDir + "/" + gameId
+	fInfo, err := os.Stat(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, NewGameError(fasthttp.StatusNotFound, "deleted game not found").WithCode(CodeGameNotFound)
+		}
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "error while checking file", err)
+	}
+	content, err := s.readFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	game, err := Unmarshal(p, content)
+	if err != nil {
+		return nil, err
+	}
+	return &DeletedGame{Game: game, DeletedAt: fInfo.ModTime()}, nil
+}
+
+// write game into trash with its deletion time, e.g. when trash is copied to another storage
+func (s *StorageFile) SaveDeleted(d *DeletedGame) error {
+	buf, err := s.encode(d.Game.MarshalRecord())
+	if err != nil {
+		return err
+	}
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	if err = os.MkdirAll(s.path+"/"+trashDir, 0750); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't create trash dir", err)
+	}
+	fname := s.path + "/" + trashDir + "/" + d.Game.id
+	if err = ioutil.WriteFile(fname, buf, 0640); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't write deleted game file", err)
+	}
+	if err = os.Chtimes(fname, d.DeletedAt, d.DeletedAt); err != nil {
+		s.log.Printf("game %s: can't set deletion time: %s", d.Game.id, err)
+	}
+	return nil
+}
+
+// move deleted game back from trash
+func (s *StorageFile) Undelete(gameId string) error {
+	if !s.IsValidGameId(gameId) {
+		return NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(CodeInvalidGameId)
+	}
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	fname := s.path + "/" + trashDir + "/" + gameId
+	if _, err := os.Stat(fname); err != nil {
+		if os.IsNotExist(err) {
+			return NewGameError(fasthttp.StatusNotFound, "deleted game not found").WithCode(CodeGameNotFound)
+		}
+		return NewGameError(fasthttp.StatusInternalServerError, "error while checking file", err)
+	}
+	if _, err := os.Stat(s.path + "/" + gameId); err == nil {
+		return NewGameError(fasthttp.StatusConflict, "game already exists").WithCode(CodeGameExists)
+	}
+
+	if err := os.Rename(fname, s.path+"/"+gameId); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't restore game file", err)
+	}
+	return nil
+}
+
+// move finished game to archive. Archived games are not listed with other games and are kept forever
+func (s *StorageFile) Archive(gameId string) error {
+	if !s.IsValidGameId(gameId) {
+		return NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(CodeInvalidGameId)
+	}
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	content, err := s.readFile(s.path + "/" + gameId)
+	if err != nil {
+		return err
+	}
+	p := s.parserPool.Get()
+	game, err := Unmarshal(p, content)
+	s.parserPool.Put(p)
+	if err != nil {
+		return err
+	}
+	if game.Status() == RUNNING {
+		return NewGameError(fasthttp.StatusConflict, "running game can't be archived").WithCode(CodeGameRunning)
+	}
+
+	if err = os.MkdirAll(s.path+"/"+archiveDir, 0750); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't create archive dir", err)
+	}
+	if err = os.Rename(s.path+"/"+gameId, s.path+"/"+archiveDir+"/"+gameId); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't move game file to archive", err)
+	}
+	return nil
+}
+
+func (s *StorageFile) ListArchived() ([]*Game, error) {
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+
+	files, err := ioutil.ReadDir(s.path + "/" + archiveDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Game{}, nil
+		}
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read archive dir", err)
+	}
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	res := make([]*Game, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !s.IsValidGameId(f.Name()) {
+			continue
+		}
+		content, err := s.readFile(s.path + "/" + archiveDir + "/" + f.Name())
+		if err != nil {
+			s.log.Errorf("archived game %s is broken and skipped: %s", f.Name(), err)
+			continue
+		}
+		game, err := Unmarshal(p, content)
+		if err != nil {
+			s.log.Errorf("archived game %s is broken and skipped: %s", f.Name(), err)
+			continue
+		}
+		res = append(res, game)
+	}
+	return res, nil
+}
+
+// permanently remove games deleted before `before`
+func (s *StorageFile) PurgeDeleted(before time.Time) (int, error) {
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	files, err := ioutil.ReadDir(s.path + "/" + trashDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, NewGameError(fasthttp.StatusInternalServerError, "can't read trash dir", err)
+	}
+
+	purged := 0
+	for _, f := range files {
+		if f.IsDir() || !f.ModTime().Before(before) {
+			continue
+		}
+		if err = os.Remove(s.path + "/" + trashDir + "/" + f.Name()); err != nil {
+			return purged, NewGameError(fasthttp.StatusInternalServerError, "can't remove deleted game file", err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// keys are arbitrary strings, so files are named by key hash
+func (s *StorageFile) responseFile(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return s.path + "/" + idempotencyDir + "/" + hex.EncodeToString(sum[:])
+}
+
+func (s *StorageFile) GetIdempotentResponse(key string) (*IdempotentResponse, error) {
+	s.rwm.RLock()
+	content, err := s.readFileLimit(s.responseFile(key), maxResponseFileSize)
+	s.rwm.RUnlock()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, NewGameError(fasthttp.StatusNotFound, "response not found")
+		}
+		return nil, err
+	}
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	r, err := UnmarshalIdempotentResponse(p, content)
+	if err != nil {
+		return nil, err
+	}
+	// protect from hash collisions
+	if r.Key != key {
+		return nil, NewGameError(fasthttp.StatusNotFound, "response not found")
+	}
+	return r, nil
+}
+
+func (s *StorageFile) SaveIdempotentResponse(r *IdempotentResponse) error {
+	buf, err := s.encode(r.Marshal())
+	if err != nil {
+		return err
+	}
+	if len(buf) > maxResponseFileSize {
+		return NewGameError(fasthttp.StatusInternalServerError, "response too large to be saved")
+	}
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	if err := os.MkdirAll(s.path+"/"+idempotencyDir, 0750); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't create idempotency dir", err)
+	}
+	if err := ioutil.WriteFile(s.responseFile(r.Key), buf, 0640); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't write response file", err)
+	}
+	return nil
+}
+
+// remove responses saved before `before`
+func (s *StorageFile) PurgeIdempotentResponses(before time.Time) (int, error) {
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	files, err := ioutil.ReadDir(s.path + "/" + idempotencyDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, NewGameError(fasthttp.StatusInternalServerError, "can't read idempotency dir", err)
+	}
+
+	purged := 0
+	for _, f := range files {
+		if f.IsDir() || !f.ModTime().Before(before) {
+			continue
+		}
+		if err = os.Remove(s.path + "/" + idempotencyDir + "/" + f.Name()); err != nil {
+			return purged, NewGameError(fasthttp.StatusInternalServerError, "can't remove response file", err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// names are case insensitive and arbitrary, so name index files are named by hash of lowercase name
+func (s *StorageFile) userNameFile(name string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(name)))
+	return s.path + "/" + userNamesDir + "/" + hex.EncodeToString(sum[:])
+}
+
+// save new user, names are unique
+func (s *StorageFile) CreateUser(u *User) error {
+	if !s.IsValidGameId(u.Id) {
+		return NewGameError(fasthttp.StatusBadRequest, "invalid user id")
+	}
+	record, err := s.encode(u.Marshal())
+	if err != nil {
+		return err
+	}
+	index, err := s.encode([]byte(u.Id))
+	if err != nil {
+		return err
+	}
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	for _, dir := range []string{usersDir, userNamesDir} {
+		if err = os.MkdirAll(s.path+"/"+dir, 0750); err != nil {
+			return NewGameError(fasthttp.StatusInternalServerError, "can't create users dir", err)
+		}
+	}
+
+	nameFile := s.userNameFile(u.Name)
+	if _, err = os.Stat(nameFile); err == nil {
+		return NewGameError(fasthttp.StatusConflict, "user name is already taken").WithCode(CodeUserExists)
+	} else if !os.IsNotExist(err) {
+		return NewGameError(fasthttp.StatusInternalServerError, "error while checking file", err)
+	}
+
+	// user is written first, so name index never refers to missing user
+	if err = ioutil.WriteFile(s.path+"/"+usersDir+"/"+u.Id, record, 0640); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't write user file", err)
+	}
+	if err = ioutil.WriteFile(nameFile, index, 0640); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't write user name file", err)
+	}
+	return nil
+}
+
+func (s *StorageFile) GetUser(userId string) (*User, error) {
+	if !s.IsValidGameId(userId) {
+		return nil, NewGameError(fasthttp.StatusNotFound, "user not found").WithCode(CodeUserNotFound)
+	}
+
+	s.rwm.RLock()
+	content, err := s.readFile(s.path + "/" + usersDir + "/" + userId)
+	s.rwm.RUnlock()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, NewGameError(fasthttp.StatusNotFound, "user not found").WithCode(CodeUserNotFound)
+		}
+		return nil, err
+	}
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	return UnmarshalUser(p, content)
+}
+
+func (s *StorageFile) GetUserByName(name string) (*User, error) {
+	s.rwm.RLock()
+	userId, err := s.readFile(s.userNameFile(name))
+	s.rwm.RUnlock()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, NewGameError(fasthttp.StatusNotFound, "user not found").WithCode(CodeUserNotFound)
+		}
+		return nil, err
+	}
+
+	u, err := s.GetUser(string(userId))
+	if err != nil {
+		return nil, err
+	}
+	// protect from hash collisions
+	if !strings.EqualFold(u.Name, name) {
+		return nil, NewGameError(fasthttp.StatusNotFound, "user not found").WithCode(CodeUserNotFound)
+	}
+	return u, nil
+}
+
+func (s *StorageFile) ListUsers() ([]*User, error) {
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+
+	files, err := ioutil.ReadDir(s.path + "/" + usersDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*User{}, nil
+		}
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read users dir", err)
+	}
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	res := make([]*User, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !s.IsValidGameId(f.Name()) {
+			continue
+		}
+		content, err := s.readFile(s.path + "/" + usersDir + "/" + f.Name())
+		if err != nil {
+			s.log.Errorf("user %s is broken and skipped: %s", f.Name(), err)
+			continue
+		}
+		u, err := UnmarshalUser(p, content)
+		if err != nil {
+			s.log.Errorf("user %s is broken and skipped: %s", f.Name(), err)
+			continue
+		}
+		res = append(res, u)
+	}
+	return res, nil
+}
+
+// token hashes are hex strings, they are used as file names as is
+func (s *StorageFile) sessionFile(tokenHash string) (string, error) {
+	if _, err := hex.DecodeString(tokenHash); err != nil || len(tokenHash) != 2*sha256.Size {
+		return "", NewGameError(fasthttp.StatusNotFound, "session not found")
+	}
+	return s.path + "/" + sessionsDir + "/" + tokenHash, nil
+}
+
+func (s *StorageFile) SaveSession(session *Session) error {
+	fname, err := s.sessionFile(session.TokenHash)
+	if err != nil {
+		return err
+	}
+	buf, err := s.encode(session.Marshal())
+	if err != nil {
+		return err
+	}
+
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	if err := os.MkdirAll(s.path+"/"+sessionsDir, 0750); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't create sessions dir", err)
+	}
+	if err := ioutil.WriteFile(fname, buf, 0640); err != nil {
+		return NewGameError(fasthttp.StatusInternalServerError, "can't write session file", err)
+	}
+	return nil
+}
+
+func (s *StorageFile) GetSession(tokenHash string) (*Session, error) {
+	fname, err := s.sessionFile(tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.rwm.RLock()
+	content, err := s.readFile(fname)
+	s.rwm.RUnlock()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, NewGameError(fasthttp.StatusNotFound, "session not found")
+		}
+		return nil, err
+	}
+
+	p := s.parserPool.Get()
+	defer s.parserPool.Put(p)
+
+	return UnmarshalSession(p, content)
+}
+
+func (s *StorageFile) DeleteSession(tokenHash string) error {
+	fname, err := s.sessionFile(tokenHash)
 	if err != nil {
-		return NewGameError(fasthttp.StatusInternalServerError, "can't remove game file", err)
+		return err
 	}
 
+	s.rwm.Lock()
+	defer s.rwm.Unlock()
+
+	if err = os.Remove(fname); err != nil {
+		if os.IsNotExist(err) {
+			return NewGameError(fasthttp.StatusNotFound, "session not found")
+		}
+		return NewGameError(fasthttp.StatusInternalServerError, "can't remove session file", err)
+	}
 	return nil
 }
 
+func (s *StorageFile) ListSessions() ([]*Session, error) {
+	s.rwm.RLock()
+	files, err := ioutil.ReadDir(s.path + "/" + sessionsDir)
+	s.rwm.RUnlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Session{}, nil
+		}
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't read sessions dir", err)
+	}
+
+	res := make([]*Session, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		session, err := s.GetSession(f.Name())
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		res = append(res, session)
+	}
+	return res, nil
+}
+
+// remove sessions expired before `before`
+func (s *StorageFile) PurgeSessions(before time.Time) (int, error) {
+	s.rwm.RLock()
+	files, err := ioutil.ReadDir(s.path + "/" + sessionsDir)
+	s.rwm.RUnlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, NewGameError(fasthttp.StatusInternalServerError, "can't read sessions dir", err)
+	}
+
+	purged := 0
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		session, err := s.GetSession(f.Name())
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return purged, err
+		}
+		if !session.Expires.Before(before) {
+			continue
+		}
+		if err = s.DeleteSession(f.Name()); err != nil && !isNotFound(err) {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
 func (s *StorageFile) Shutdown() error {
 	// sleep a second to wait all read/write storage operations will be done
 	time.Sleep(1 * time.Second)
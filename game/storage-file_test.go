@@ -0,0 +1,668 @@
+package game
+
+import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLogger() *log.Logger {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	return logger
+}
+
+func newTestStorage(t *testing.T) *StorageFile {
+	dir, err := ioutil.TempDir("", "tic-tac-toe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	if err = os.Mkdir(filepath.Join(dir, "storage"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStorage(filepath.Join(dir, "storage"), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStorageFile_Lock(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the other storage of the same dir, e.g. opened by offline command
+	other, err := NewStorage(s.path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = other.Lock(); err == nil || AsGameError(err).Status != fasthttp.StatusConflict {
+		t.Fatalf("locked storage is locked again: %v", err)
+	}
+
+	if err = s.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = other.Lock(); err != nil {
+		t.Fatalf("unlocked storage isn't locked: %s", err)
+	}
+	_ = other.Unlock()
+}
+
+func TestStorageFile_SnapshotRestore(t *testing.T) {
+	s := newTestStorage(t)
+
+	g1 := NewGame([]byte("X--------"), XChar)
+	g2 := NewGame([]byte("----O----"), OChar)
+	for _, g := range []*Game{g1, g2} {
+		if err := s.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := s.Snapshot(&buf); err != nil {
+		t.Fatalf("snapshot failed: %s", err)
+	}
+
+	if err := s.Delete(g1.Id()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RestoreSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("restore failed: %s", err)
+	}
+
+	for _, g := range []*Game{g1, g2} {
+		restored, err := s.Get(g.Id())
+		if err != nil {
+			t.Fatalf("game %s is not restored: %s", g.Id(), err)
+		}
+		if !bytes.Equal(restored.MarshalRecord(), g.MarshalRecord()) {
+			t.Fatalf("game %s restored as %s", g.MarshalRecord(), restored.MarshalRecord())
+		}
+	}
+
+	// broken snapshot must not touch storage
+	if err := s.RestoreSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+		t.Fatal("broken snapshot restored")
+	}
+	if ok, _ := s.IsGameExists(g1.Id()); !ok {
+		t.Fatal("storage changed by broken snapshot")
+	}
+}
+
+func TestStorageFile_SnapshotOversized(t *testing.T) {
+	s := newTestStorage(t)
+	g := NewGame([]byte("X---O----"), XChar)
+	if err := s.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.path, g.Id()), make([]byte, maxFileSize+1), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err := s.Snapshot(&buf)
+	if err == nil || !strings.Contains(err.Error(), g.Id()) {
+		t.Fatalf("snapshot with oversized game: got error %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Fatal("failed snapshot is written")
+	}
+}
+
+func TestStorageFile_MaxFileSize(t *testing.T) {
+	s := newTestStorage(t)
+	g := NewGame([]byte("X---O----"), XChar)
+
+	// pad owner, so game file is exactly of maximum size
+	g.SetOwner("key:")
+	g.SetOwner("key:" + strings.Repeat("a", maxFileSize-len(addChecksum(g.MarshalRecord()))))
+	if err := s.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	if fInfo, err := os.Stat(filepath.Join(s.path, g.Id())); err != nil || fInfo.Size() != maxFileSize {
+		t.Fatalf("game file size isn't maximum: %v %v", fInfo, err)
+	}
+	if _, err := s.Get(g.Id()); err != nil {
+		t.Fatalf("game of maximum size isn't read: %s", err)
+	}
+	report, err := s.Fsck(false)
+	if err != nil || len(report.Problems) > 0 {
+		t.Fatalf("game of maximum size is reported by fsck: %v %v", report, err)
+	}
+	if err = s.Snapshot(ioutil.Discard); err != nil {
+		t.Fatalf("game of maximum size isn't snapshotted: %s", err)
+	}
+
+	// one byte more
+	g.SetOwner(g.Owner() + "a")
+	if err = s.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get(g.Id()); err == nil {
+		t.Fatal("oversized game is read")
+	}
+	report, err = s.Fsck(false)
+	if err != nil || len(report.Problems) != 1 || report.Problems[0].Kind != FsckOversized {
+		t.Fatalf("oversized game isn't reported by fsck: %v %v", report, err)
+	}
+	if err = s.Snapshot(ioutil.Discard); err == nil {
+		t.Fatal("oversized game is snapshotted")
+	}
+}
+
+func TestStorageFile_SnapshotRestoreDirs(t *testing.T) {
+	s := newTestStorage(t)
+
+	deleted := NewGame([]byte("X--------"), XChar)
+	if err := s.Save(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(deleted.Id()); err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := s.GetDeleted(deleted.Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := NewUser("alice", "$2a$10$hash")
+	if err = s.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{TokenHash: strings.Repeat("ab", 32), UserId: u.Id, Created: time.Now(), Expires: time.Now().Add(time.Hour)}
+	if err = s.SaveSession(session); err != nil {
+		t.Fatal(err)
+	}
+	response := &IdempotentResponse{Key: "key-1", Status: 201, Body: []byte("{}"), Created: time.Now()}
+	if err = s.SaveIdempotentResponse(response); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err = s.Snapshot(&buf); err != nil {
+		t.Fatalf("snapshot failed: %s", err)
+	}
+
+	// restore into another storage, nothing is left from the original one
+	restored := newTestStorage(t)
+	if err = restored.RestoreSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("restore failed: %s", err)
+	}
+
+	d, err := restored.GetDeleted(deleted.Id())
+	if err != nil {
+		t.Fatalf("deleted game is not restored: %s", err)
+	}
+	// archive keeps modification time with second precision
+	if diff := d.DeletedAt.Sub(trashed.DeletedAt); diff < -time.Second || diff > time.Second {
+		t.Errorf("deletion time is changed: %s, expected %s", d.DeletedAt, trashed.DeletedAt)
+	}
+	ru, err := restored.GetUserByName("Alice")
+	if err != nil {
+		t.Fatalf("user is not restored: %s", err)
+	}
+	if ru.Id != u.Id || ru.PasswordHash != u.PasswordHash {
+		t.Errorf("user is restored as %+v", ru)
+	}
+	if _, err = restored.GetSession(session.TokenHash); err != nil {
+		t.Errorf("session is not restored: %s", err)
+	}
+	if _, err = restored.GetIdempotentResponse(response.Key); err != nil {
+		t.Errorf("idempotent response is not restored: %s", err)
+	}
+}
+
+func TestStorageFile_Archive(t *testing.T) {
+	s := newTestStorage(t)
+
+	running := NewGame([]byte("X---O----"), XChar)
+	finished := NewGame([]byte("X---O----"), XChar)
+	if err := finished.Finish(DRAW, "admin-token"); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []*Game{running, finished} {
+		if err := s.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Archive(running.Id()); AsGameError(err).Code() != CodeGameRunning {
+		t.Fatalf("running game is archived: %v", err)
+	}
+	if err := s.Archive(finished.Id()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.IsGameExists(finished.Id()); ok {
+		t.Fatal("archived game is still in storage")
+	}
+	archived, err := s.ListArchived()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || !bytes.Equal(archived[0].MarshalRecord(), finished.MarshalRecord()) {
+		t.Fatalf("unexpected archived games %v", archived)
+	}
+	if err = s.Archive(finished.Id()); AsGameError(err).Status != 404 {
+		t.Fatalf("archived game is archived again: %v", err)
+	}
+}
+
+func TestStorageFile_Encryption(t *testing.T) {
+	s := newTestStorage(t)
+
+	plain := NewGame([]byte("X--------"), XChar)
+	if err := s.Save(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	k, err := NewKeyring([][]byte{oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetKeyring(k)
+
+	// plaintext games are still readable
+	if _, err = s.Get(plain.Id()); err != nil {
+		t.Fatalf("plaintext game is not readable: %s", err)
+	}
+
+	g := NewGame([]byte("----O----"), OChar)
+	if err = s.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.path, g.Id()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) || bytes.Contains(data, []byte(g.Id())) {
+		t.Fatal("game is stored in plaintext")
+	}
+
+	// rotate key: old games are readable, new saves use new key
+	k, err = NewKeyring([][]byte{newKey, oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetKeyring(k)
+	raw, err := s.GetRaw(g.Id())
+	if err != nil || !bytes.Equal(raw, g.MarshalRecord()) {
+		t.Fatalf("game encrypted with old key: %s: %s", raw, err)
+	}
+	if err = s.Save(g); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err = NewKeyring([][]byte{newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetKeyring(k)
+	if _, err = s.Get(g.Id()); err != nil {
+		t.Fatalf("game is not re-encrypted on save: %s", err)
+	}
+}
+
+func TestStorageFile_FsckFinishedByAdmin(t *testing.T) {
+	s := newTestStorage(t)
+	g := NewGame([]byte("X---O----"), XChar)
+	if err := g.Finish(XWON, "key:alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(g); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("game finished by admin is reported: %+v", report.Problems)
+	}
+	if ok, _ := s.IsGameExists(g.Id()); !ok {
+		t.Fatal("game finished by admin is quarantined")
+	}
+}
+
+func TestStorageFile_Fsck(t *testing.T) {
+	s := newTestStorage(t)
+
+	ok := NewGame([]byte("X---O----"), XChar)
+	corrupt := NewGame([]byte("X---O----"), XChar)
+	orphaned := NewGame([]byte("X---O----"), XChar)
+	for _, g := range []*Game{ok, corrupt, orphaned} {
+		if err := s.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// save was interrupted after game file was renamed to backup
+	if err := os.Rename(filepath.Join(s.path, orphaned.Id()), filepath.Join(s.path, orphaned.Id()+backupExt)); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		corrupt.Id():               []byte(`{"id":"` + corrupt.Id() + `","board":"XXXXXXXXX","status":"RUNNING"}` + "\ncrc32c:00000000"),
+		"junk":                     []byte("junk"),
+		ok.Id() + backupExt:        ok.Marshal(),
+		"f" + ok.Id()[1:] + ".bak": ok.Marshal(),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(s.path, name), content, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := s.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]string{}
+	for _, p := range report.Problems {
+		if p.Name == orphaned.Id()+backupExt {
+			if p.Kind != FsckOrphanedBackup || !p.Restored || p.Quarantined {
+				t.Fatalf("valid orphaned backup is not restored: %+v", p)
+			}
+			continue
+		}
+		kinds[p.Name] = p.Kind
+	}
+	expected := map[string]string{
+		corrupt.Id():               FsckCorrupt,
+		"junk":                     FsckInvalidName,
+		ok.Id() + backupExt:        FsckStaleBackup,
+		"f" + ok.Id()[1:] + ".bak": FsckOrphanedBackup,
+	}
+	if len(kinds) != len(expected) {
+		t.Fatalf("expected problems %v, got %v", expected, kinds)
+	}
+	for name, kind := range expected {
+		if kinds[name] != kind {
+			t.Fatalf("%s: expected %s, got %s", name, kind, kinds[name])
+		}
+		if _, err := os.Stat(filepath.Join(s.path, quarantineDir, name)); err != nil {
+			t.Fatalf("%s is not quarantined: %s", name, err)
+		}
+	}
+
+	if _, err = s.Get(ok.Id()); err != nil {
+		t.Fatalf("valid game is broken by fsck: %s", err)
+	}
+	if g, err := s.Get(orphaned.Id()); err != nil || !bytes.Equal(g.MarshalRecord(), orphaned.MarshalRecord()) {
+		t.Fatalf("game is not restored from orphaned backup: %v", err)
+	}
+}
+
+func TestStorageFile_Trash(t *testing.T) {
+	s := newTestStorage(t)
+
+	g := NewGame([]byte("X---O----"), XChar)
+	g.SetOwner("key:alice")
+	if err := s.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(g.Id()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.IsGameExists(g.Id()); ok {
+		t.Fatal("deleted game exists")
+	}
+
+	d, err := s.GetDeleted(g.Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Game.Owner() != "key:alice" || time.Since(d.DeletedAt) > time.Minute {
+		t.Fatalf("unexpected deleted game: %+v", d)
+	}
+	if _, err = s.GetDeleted("a0000000-0000-0000-0000-000000000000"); !isNotFound(err) {
+		t.Fatalf("unexpected error for unknown deleted game: %v", err)
+	}
+
+	deleted, err := s.ListDeleted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Game.Id() != g.Id() || time.Since(deleted[0].DeletedAt) > time.Minute {
+		t.Fatalf("unexpected deleted games: %v", deleted)
+	}
+
+	if err = s.Undelete(g.Id()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get(g.Id()); err != nil {
+		t.Fatalf("game is not restored: %s", err)
+	}
+	if err = s.Undelete(g.Id()); err == nil {
+		t.Fatal("game restored twice")
+	}
+
+	if err = s.Delete(g.Id()); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.PurgeDeleted(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("recently deleted game purged: %d: %v", n, err)
+	}
+	if n, err := s.PurgeDeleted(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("deleted game is not purged: %d: %v", n, err)
+	}
+	if err = s.Undelete(g.Id()); err == nil {
+		t.Fatal("purged game restored")
+	}
+}
+
+func TestStorageFile_IdempotentResponse(t *testing.T) {
+	s := newTestStorage(t)
+
+	if _, err := s.GetIdempotentResponse("key"); !isNotFound(err) {
+		t.Fatalf("unexpected error for unknown key: %v", err)
+	}
+
+	r := &IdempotentResponse{
+		Key:         "key/../with \"quotes\"",
+		Fingerprint: "abc",
+		Status:      201,
+		ContentType: "application/json",
+		Location:    "https://localhost/api/v1/games/1",
+		Body:        []byte(`{"board":"X--------"}`),
+		Created:     time.Now(),
+	}
+	if err := s.SaveIdempotentResponse(r); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := s.GetIdempotentResponse(r.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Key != r.Key || saved.Status != r.Status || saved.Location != r.Location || string(saved.Body) != string(r.Body) || !saved.Created.Equal(r.Created) {
+		t.Fatalf("unexpected saved response %+v", saved)
+	}
+	if games, err := s.List(); err != nil || len(games) != 0 {
+		t.Fatalf("saved response is listed as game: %v: %v", games, err)
+	}
+
+	if n, err := s.PurgeIdempotentResponses(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("recent response purged: %d: %v", n, err)
+	}
+	if n, err := s.PurgeIdempotentResponses(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("response is not purged: %d: %v", n, err)
+	}
+}
+
+func TestStorageFile_Users(t *testing.T) {
+	s := newTestStorage(t)
+
+	u := NewUser("Alice", "hash")
+	if err := s.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	err := s.CreateUser(NewUser("alice", "other"))
+	if gErr, ok := err.(*GameError); !ok || gErr.Code() != CodeUserExists {
+		t.Fatalf("unexpected error for taken name: %v", err)
+	}
+
+	byName, err := s.GetUserByName("ALICE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byId, err := s.GetUser(u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, saved := range []*User{byName, byId} {
+		if saved.Id != u.Id || saved.Name != "Alice" || saved.PasswordHash != "hash" || !saved.Created.Equal(u.Created) {
+			t.Fatalf("unexpected saved user %+v", saved)
+		}
+	}
+	if _, err = s.GetUserByName("bob"); !isNotFound(err) {
+		t.Fatalf("unexpected error for unknown user: %v", err)
+	}
+	if games, err := s.List(); err != nil || len(games) != 0 {
+		t.Fatalf("user is listed as game: %v: %v", games, err)
+	}
+}
+
+func TestStorageFile_Sessions(t *testing.T) {
+	s := newTestStorage(t)
+
+	if _, err := s.GetSession("../" + strings.Repeat("0", 61)); !isNotFound(err) {
+		t.Fatalf("unexpected error for invalid token hash: %v", err)
+	}
+
+	now := time.Now()
+	expired := &Session{TokenHash: strings.Repeat("a", 64), UserId: "u1", Created: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour)}
+	active := &Session{TokenHash: strings.Repeat("b", 64), UserId: "u2", Created: now, Expires: now.Add(time.Hour)}
+	for _, session := range []*Session{expired, active} {
+		if err := s.SaveSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved, err := s.GetSession(active.TokenHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.UserId != "u2" || !saved.Expires.Equal(active.Expires) {
+		t.Fatalf("unexpected saved session %+v", saved)
+	}
+
+	if n, err := s.PurgeSessions(now); err != nil || n != 1 {
+		t.Fatalf("expired session is not purged: %d: %v", n, err)
+	}
+	if _, err = s.GetSession(expired.TokenHash); !isNotFound(err) {
+		t.Fatalf("unexpected error for purged session: %v", err)
+	}
+
+	if err = s.DeleteSession(active.TokenHash); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteSession(active.TokenHash); !isNotFound(err) {
+		t.Fatalf("unexpected error for deleted session: %v", err)
+	}
+}
+
+func TestMigrationStorage_Backfill(t *testing.T) {
+	from, to := newTestStorage(t), newTestStorage(t)
+
+	old := NewGame([]byte("X---O----"), XChar)
+	diverged := NewGame([]byte("X---O----"), XChar)
+	for _, g := range []*Game{old, diverged} {
+		if err := from.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := to.Save(&Game{id: diverged.id, board: []byte("---------"), status: RUNNING}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewMigrationStorage(from, to, from.log)
+	g := NewGame([]byte("----O----"), OChar)
+	if err := s.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := to.Get(g.Id()); err != nil {
+		t.Fatalf("game is not written to new storage: %s", err)
+	}
+
+	report, err := s.Backfill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 3 || report.Copied != 1 || report.Diverged != 1 || report.Failed != 0 {
+		t.Fatalf("unexpected backfill report: %+v", report)
+	}
+	for _, g := range []*Game{old, diverged} {
+		raw, err := to.GetRaw(g.Id())
+		if err != nil || !bytes.Equal(raw, g.MarshalRecord()) {
+			t.Fatalf("game %s is not copied: %s: %v", g.MarshalRecord(), raw, err)
+		}
+	}
+
+	if err = s.Delete(old.Id()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := to.IsGameExists(old.Id()); ok {
+		t.Fatal("game is not deleted from new storage")
+	}
+
+	// trash and archive of the old storage only
+	deleted := NewGame([]byte("X---O----"), XChar)
+	archived := NewGame([]byte("X---O----"), XChar)
+	if err = archived.Finish(DRAW, "admin-token"); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []*Game{deleted, archived} {
+		if err = from.Save(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = from.Delete(deleted.Id()); err != nil {
+		t.Fatal(err)
+	}
+	if err = from.Archive(archived.Id()); err != nil {
+		t.Fatal(err)
+	}
+	deletedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err = os.Chtimes(filepath.Join(from.path, trashDir, deleted.Id()), deletedAt, deletedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if report, err = s.Backfill(); err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 1 || report.Archived != 1 || report.Failed != 0 {
+		t.Fatalf("unexpected backfill report: %+v", report)
+	}
+	d, err := to.GetDeleted(deleted.Id())
+	if err != nil || !d.DeletedAt.Equal(deletedAt) || !bytes.Equal(d.Game.MarshalRecord(), deleted.MarshalRecord()) {
+		t.Fatalf("deleted game is not copied: %+v, %v", d, err)
+	}
+	if _, err = to.GetDeleted(old.Id()); err != nil {
+		t.Fatalf("deleted game is not in trash of new storage: %s", err)
+	}
+	toArchived, err := to.ListArchived()
+	if err != nil || len(toArchived) != 1 || toArchived[0].Id() != archived.Id() {
+		t.Fatalf("archived game is not copied: %v, %v", toArchived, err)
+	}
+
+	// the next backfill finds nothing to copy
+	if report, err = s.Backfill(); err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 0 || report.Diverged != 0 || report.Deleted != 0 || report.Archived != 0 {
+		t.Fatalf("unexpected backfill report: %+v", report)
+	}
+}
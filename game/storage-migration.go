@@ -0,0 +1,518 @@
+package game
+
+import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// storage wrapper for migration between backends. Reads go to the old storage, writes go to
+// both of them. Backfill copies games saved before migration started and repairs divergence,
+// so the new storage could replace the old one without losing games
+type MigrationStorage struct {
+	from Storage // the old storage
+	to   Storage // the new storage
+	log  *log.Logger
+
+	failedWrites uint64 // writes failed on new storage since last backfill, atomic
+
+	// game writes hold read lock, backfill holds write lock while it copies a game, so the copy
+	// can't overwrite newer game in the new storage
+	writes sync.RWMutex
+
+	mu         sync.Mutex
+	lastReport *BackfillReport
+	stop       chan struct{}
+}
+
+type BackfillReport struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Checked  int       `json:"checked"`  // number of games in old storage
+	Copied   int       `json:"copied"`   // games missing in new storage
+	Diverged int       `json:"diverged"` // games which differ in new storage, they are overwritten
+	Extra    int       `json:"extra"`    // games which exist in new storage only
+	Failed   int       `json:"failed"`   // games, users and sessions which could not be copied
+	Users    int       `json:"users"`    // users copied to new storage
+	Sessions int       `json:"sessions"` // sessions copied to new storage
+	Deleted  int       `json:"deleted"`  // deleted games copied to trash of new storage
+	Archived int       `json:"archived"` // archived games copied to new storage
+	// writes failed on new storage before this backfill
+	FailedWrites uint64 `json:"failed_writes"`
+}
+
+func NewMigrationStorage(from, to Storage, logger *log.Logger) *MigrationStorage {
+	return &MigrationStorage{
+		from: from,
+		to:   to,
+		log:  logger,
+		stop: make(chan struct{}),
+	}
+}
+
+func (s *MigrationStorage) Get(gameId string) (*Game, error) {
+	return s.from.Get(gameId)
+}
+
+func (s *MigrationStorage) GetRaw(gameId string) ([]byte, error) {
+	return s.from.GetRaw(gameId)
+}
+
+func (s *MigrationStorage) List() ([]*Game, error) {
+	return s.from.List()
+}
+
+func (s *MigrationStorage) ListRaw() ([][]byte, error) {
+	return s.from.ListRaw()
+}
+
+// the old storage is the source of truth, failed writes to the new one are repaired by backfill
+func (s *MigrationStorage) Save(game *Game) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if err := s.from.Save(game); err != nil {
+		return err
+	}
+	if err := s.to.Save(game); err != nil {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: game %s: can't save to new storage: %s", game.id, err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) Delete(gameId string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if err := s.from.Delete(gameId); err != nil {
+		return err
+	}
+	if err := s.to.Delete(gameId); err != nil && !isNotFound(err) {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: game %s: can't delete from new storage: %s", gameId, err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) ListDeleted() ([]*DeletedGame, error) {
+	return s.from.ListDeleted()
+}
+
+func (s *MigrationStorage) GetDeleted(gameId string) (*DeletedGame, error) {
+	return s.from.GetDeleted(gameId)
+}
+
+func (s *MigrationStorage) SaveDeleted(d *DeletedGame) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if err := s.from.SaveDeleted(d); err != nil {
+		return err
+	}
+	if err := s.to.SaveDeleted(d); err != nil {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: game %s: can't save to trash of new storage: %s", d.Game.id, err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) Undelete(gameId string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if err := s.from.Undelete(gameId); err != nil {
+		return err
+	}
+	if err := s.to.Undelete(gameId); err != nil && !isNotFound(err) {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: game %s: can't restore in new storage: %s", gameId, err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) Archive(gameId string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if err := s.from.Archive(gameId); err != nil {
+		return err
+	}
+	if err := s.to.Archive(gameId); err != nil && !isNotFound(err) {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: game %s: can't archive in new storage: %s", gameId, err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) ListArchived() ([]*Game, error) {
+	return s.from.ListArchived()
+}
+
+func (s *MigrationStorage) PurgeDeleted(before time.Time) (int, error) {
+	n, err := s.from.PurgeDeleted(before)
+	if err != nil {
+		return n, err
+	}
+	if _, err := s.to.PurgeDeleted(before); err != nil {
+		s.log.Errorln("migration: can't purge deleted games in new storage:", err)
+	}
+	return n, nil
+}
+
+func (s *MigrationStorage) GetIdempotentResponse(key string) (*IdempotentResponse, error) {
+	return s.from.GetIdempotentResponse(key)
+}
+
+func (s *MigrationStorage) SaveIdempotentResponse(r *IdempotentResponse) error {
+	if err := s.from.SaveIdempotentResponse(r); err != nil {
+		return err
+	}
+	if err := s.to.SaveIdempotentResponse(r); err != nil {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: can't save idempotent response to new storage: %s", err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) PurgeIdempotentResponses(before time.Time) (int, error) {
+	n, err := s.from.PurgeIdempotentResponses(before)
+	if err != nil {
+		return n, err
+	}
+	if _, err := s.to.PurgeIdempotentResponses(before); err != nil {
+		s.log.Errorln("migration: can't purge idempotent responses in new storage:", err)
+	}
+	return n, nil
+}
+
+func (s *MigrationStorage) CreateUser(u *User) error {
+	if err := s.from.CreateUser(u); err != nil {
+		return err
+	}
+	if err := s.to.CreateUser(u); err != nil {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: can't create user %s in new storage: %s", u.Id, err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) GetUser(userId string) (*User, error) {
+	return s.from.GetUser(userId)
+}
+
+func (s *MigrationStorage) GetUserByName(name string) (*User, error) {
+	return s.from.GetUserByName(name)
+}
+
+func (s *MigrationStorage) ListUsers() ([]*User, error) {
+	return s.from.ListUsers()
+}
+
+func (s *MigrationStorage) SaveSession(session *Session) error {
+	if err := s.from.SaveSession(session); err != nil {
+		return err
+	}
+	if err := s.to.SaveSession(session); err != nil {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: can't save session to new storage: %s", err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) GetSession(tokenHash string) (*Session, error) {
+	return s.from.GetSession(tokenHash)
+}
+
+func (s *MigrationStorage) DeleteSession(tokenHash string) error {
+	if err := s.from.DeleteSession(tokenHash); err != nil {
+		return err
+	}
+	if err := s.to.DeleteSession(tokenHash); err != nil && !isNotFound(err) {
+		atomic.AddUint64(&s.failedWrites, 1)
+		s.log.Errorf("migration: can't delete session in new storage: %s", err)
+	}
+	return nil
+}
+
+func (s *MigrationStorage) ListSessions() ([]*Session, error) {
+	return s.from.ListSessions()
+}
+
+func (s *MigrationStorage) PurgeSessions(before time.Time) (int, error) {
+	n, err := s.from.PurgeSessions(before)
+	if err != nil {
+		return n, err
+	}
+	if _, err := s.to.PurgeSessions(before); err != nil {
+		s.log.Errorln("migration: can't purge sessions in new storage:", err)
+	}
+	return n, nil
+}
+
+func (s *MigrationStorage) Shutdown() error {
+	close(s.stop)
+
+	err := s.from.Shutdown()
+	if toErr := s.to.Shutdown(); toErr != nil {
+		s.log.Errorln("migration: new storage shutdown error:", toErr)
+	}
+	return err
+}
+
+func (s *MigrationStorage) IsValidGameId(gameId string) bool {
+	return s.from.IsValidGameId(gameId)
+}
+
+func (s *MigrationStorage) IsGameExists(gameId string) (bool, error) {
+	return s.from.IsGameExists(gameId)
+}
+
+// snapshot of the old storage, it is the source of truth
+func (s *MigrationStorage) Snapshot(w io.Writer) error {
+	if sn, ok := s.from.(Snapshotter); ok {
+		return sn.Snapshot(w)
+	}
+	return NewGameError(fasthttp.StatusNotImplemented, "storage doesn't support snapshots")
+}
+
+func (s *MigrationStorage) RestoreSnapshot(r io.Reader) error {
+	return NewGameError(fasthttp.StatusNotImplemented, "restore is not supported during migration")
+}
+
+// copy games missing in the new storage and overwrite diverged ones, then copy trash, archive,
+// users and sessions
+func (s *MigrationStorage) Backfill() (*BackfillReport, error) {
+	report := &BackfillReport{
+		Started:      time.Now(),
+		FailedWrites: atomic.SwapUint64(&s.failedWrites, 0),
+	}
+
+	games, err := s.from.List()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(games))
+	for _, g := range games {
+		ids[g.id] = true
+		report.Checked++
+
+		// game could be changed since it was listed, it is compared again before copy
+		if raw, err := s.to.GetRaw(g.id); err == nil && bytes.Equal(raw, g.MarshalRecord()) {
+			continue
+		}
+		s.backfillGame(g.id, report)
+	}
+
+	if err = s.backfillTrash(report); err != nil {
+		return nil, err
+	}
+	if err = s.backfillArchive(report); err != nil {
+		return nil, err
+	}
+	if err = s.backfillAccounts(report); err != nil {
+		return nil, err
+	}
+
+	toGames, err := s.to.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range toGames {
+		if !ids[g.id] {
+			// game could be saved after old storage was listed
+			if ok, _ := s.from.IsGameExists(g.id); !ok {
+				report.Extra++
+				s.log.Warnf("migration: game %s exists in new storage only", g.id)
+			}
+		}
+	}
+
+	report.Finished = time.Now()
+
+	s.mu.Lock()
+	s.lastReport = report
+	s.mu.Unlock()
+
+	return report, nil
+}
+
+// copy the latest state of game if it is missing or differs in the new storage
+func (s *MigrationStorage) backfillGame(gameId string, report *BackfillReport) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	g, err := s.from.Get(gameId)
+	if isNotFound(err) {
+		// game is deleted or archived since it was listed
+		return
+	}
+	if err != nil {
+		report.Failed++
+		s.log.Errorf("migration: game %s: can't read from old storage: %s", gameId, err)
+		return
+	}
+
+	raw, err := s.to.GetRaw(gameId)
+	switch {
+	case err == nil && bytes.Equal(raw, g.MarshalRecord()):
+		return
+	case err == nil:
+		report.Diverged++
+		s.log.Warnf("migration: game %s differs in new storage", gameId)
+	case isNotFound(err):
+		report.Copied++
+	default:
+		report.Failed++
+		s.log.Errorf("migration: game %s: can't read from new storage: %s", gameId, err)
+		return
+	}
+
+	if err = s.to.Save(g); err != nil {
+		report.Failed++
+		s.log.Errorf("migration: game %s: can't copy to new storage: %s", gameId, err)
+	}
+}
+
+// copy deleted games missing in trash of the new storage with their deletion time
+func (s *MigrationStorage) backfillTrash(report *BackfillReport) error {
+	deleted, err := s.from.ListDeleted()
+	if err != nil {
+		return err
+	}
+	for _, d := range deleted {
+		if _, err = s.to.GetDeleted(d.Game.id); !isNotFound(err) {
+			continue
+		}
+
+		s.writes.Lock()
+		// game could be restored since it was listed
+		if d, err = s.from.GetDeleted(d.Game.id); err == nil {
+			err = s.to.SaveDeleted(d)
+		}
+		s.writes.Unlock()
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			report.Failed++
+			s.log.Errorf("migration: deleted game: can't copy to new storage: %s", err)
+			continue
+		}
+		report.Deleted++
+	}
+	return nil
+}
+
+// copy archived games missing in the new storage, they are never changed
+func (s *MigrationStorage) backfillArchive(report *BackfillReport) error {
+	archived, err := s.from.ListArchived()
+	if err != nil {
+		return err
+	}
+	toArchived, err := s.to.ListArchived()
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]bool, len(toArchived))
+	for _, g := range toArchived {
+		ids[g.id] = true
+	}
+
+	for _, g := range archived {
+		if ids[g.id] {
+			continue
+		}
+		s.writes.Lock()
+		err = s.to.Save(g)
+		if err == nil {
+			err = s.to.Archive(g.id)
+		}
+		s.writes.Unlock()
+		if err != nil {
+			report.Failed++
+			s.log.Errorf("migration: archived game %s: can't copy to new storage: %s", g.id, err)
+			continue
+		}
+		report.Archived++
+	}
+	return nil
+}
+
+// copy users and sessions missing in the new storage. They are never changed, so existing ones
+// are the same
+func (s *MigrationStorage) backfillAccounts(report *BackfillReport) error {
+	users, err := s.from.ListUsers()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if _, err = s.to.GetUser(u.Id); !isNotFound(err) {
+			continue
+		}
+		if err = s.to.CreateUser(u); err != nil {
+			report.Failed++
+			s.log.Errorf("migration: user %s: can't copy to new storage: %s", u.Id, err)
+			continue
+		}
+		report.Users++
+	}
+
+	sessions, err := s.from.ListSessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if _, err = s.to.GetSession(session.TokenHash); !isNotFound(err) {
+			continue
+		}
+		if err = s.to.SaveSession(session); err != nil {
+			report.Failed++
+			s.log.Errorf("migration: user %s: can't copy session to new storage: %s", session.UserId, err)
+			continue
+		}
+		report.Sessions++
+	}
+	return nil
+}
+
+// run backfill now and then periodically until shutdown
+func (s *MigrationStorage) StartBackfill(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			report, err := s.Backfill()
+			if err != nil {
+				s.log.Errorln("migration: backfill failed:", err)
+			} else {
+				s.log.Infof("migration: backfill done: checked %d, copied %d, diverged %d, extra %d, failed %d, failed writes %d, users %d, sessions %d, deleted %d, archived %d",
+					report.Checked, report.Copied, report.Diverged, report.Extra, report.Failed, report.FailedWrites, report.Users, report.Sessions, report.Deleted, report.Archived)
+			}
+
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// last backfill report, nil if backfill was not finished yet
+func (s *MigrationStorage) LastReport() *BackfillReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastReport
+}
+
+func isNotFound(err error) bool {
+	gErr, ok := err.(*GameError)
+	return ok && gErr.Status == fasthttp.StatusNotFound
+}
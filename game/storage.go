@@ -1,5 +1,10 @@
 package game
 
+import (
+	"io"
+	"time"
+)
+
 type Storage interface {
 	Get(gameId string) (*Game, error)
 	GetRaw(gameId string) ([]byte, error)
@@ -7,8 +12,45 @@ type Storage interface {
 	ListRaw() ([][]byte, error)
 	Save(game *Game) error
 	Delete(gameId string) error
+	ListDeleted() ([]*DeletedGame, error)
+	GetDeleted(gameId string) (*DeletedGame, error)
+	SaveDeleted(d *DeletedGame) error
+	Undelete(gameId string) error
+	PurgeDeleted(before time.Time) (int, error)
+	Archive(gameId string) error
+	ListArchived() ([]*Game, error)
+	GetIdempotentResponse(key string) (*IdempotentResponse, error)
+	SaveIdempotentResponse(r *IdempotentResponse) error
+	PurgeIdempotentResponses(before time.Time) (int, error)
+	CreateUser(u *User) error
+	GetUser(userId string) (*User, error)
+	GetUserByName(name string) (*User, error)
+	ListUsers() ([]*User, error)
+	SaveSession(session *Session) error
+	GetSession(tokenHash string) (*Session, error)
+	DeleteSession(tokenHash string) error
+	ListSessions() ([]*Session, error)
+	PurgeSessions(before time.Time) (int, error)
 	Shutdown() error
 
 	IsValidGameId(gameId string) bool
 	IsGameExists(gameId string) (bool, error)
 }
+
+// game moved to trash
+type DeletedGame struct {
+	Game      *Game
+	DeletedAt time.Time
+}
+
+// create json string from DeletedGame struct
+func (d *DeletedGame) Marshal() []byte {
+	g := d.Game.Marshal()
+	return append(g[:len(g)-1], `,"deleted_at":"`+d.DeletedAt.UTC().Format(time.RFC3339)+`"}`...)
+}
+
+// storage which is able to make point-in-time snapshots of its content
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+	RestoreSnapshot(r io.Reader) error
+}
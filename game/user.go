@@ -0,0 +1,103 @@
+package game
+
+import (
+	"github.com/satori/go.uuid"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"time"
+)
+
+// registered player, games created by the user belong to `user:<id>`
+type User struct {
+	Id           string
+	Name         string
+	PasswordHash string
+	Created      time.Time
+}
+
+func NewUser(name, passwordHash string) *User {
+	return &User{
+		Id:           uuid.NewV4().String(),
+		Name:         name,
+		PasswordHash: passwordHash,
+		Created:      time.Now().UTC(),
+	}
+}
+
+// create json record to be saved in storage
+func (u *User) Marshal() []byte {
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("id", a.NewString(u.Id))
+	o.Set("name", a.NewString(u.Name))
+	o.Set("password_hash", a.NewString(u.PasswordHash))
+	o.Set("created", a.NewString(u.Created.UTC().Format(time.RFC3339Nano)))
+	return o.MarshalTo(nil)
+}
+
+func UnmarshalUser(p *fastjson.Parser, buf []byte) (*User, error) {
+	v, err := p.ParseBytes(buf)
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't parse user", err)
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, string(v.GetStringBytes("created")))
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "invalid user creation time", err)
+	}
+
+	// copy values, they are valid until parser is reused
+	return &User{
+		Id:           string(v.GetStringBytes("id")),
+		Name:         string(v.GetStringBytes("name")),
+		PasswordHash: string(v.GetStringBytes("password_hash")),
+		Created:      created,
+	}, nil
+}
+
+// login session of user. Only token hash is saved, so stolen storage doesn't give access
+type Session struct {
+	TokenHash string
+	UserId    string
+	Created   time.Time
+	Expires   time.Time
+}
+
+// create json record to be saved in storage
+func (s *Session) Marshal() []byte {
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("token_hash", a.NewString(s.TokenHash))
+	o.Set("user_id", a.NewString(s.UserId))
+	o.Set("created", a.NewString(s.Created.UTC().Format(time.RFC3339Nano)))
+	o.Set("expires", a.NewString(s.Expires.UTC().Format(time.RFC3339Nano)))
+	return o.MarshalTo(nil)
+}
+
+func UnmarshalSession(p *fastjson.Parser, buf []byte) (*Session, error) {
+	v, err := p.ParseBytes(buf)
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "can't parse session", err)
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, string(v.GetStringBytes("created")))
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "invalid session creation time", err)
+	}
+	expires, err := time.Parse(time.RFC3339Nano, string(v.GetStringBytes("expires")))
+	if err != nil {
+		return nil, NewGameError(fasthttp.StatusInternalServerError, "invalid session expiration time", err)
+	}
+
+	// copy values, they are valid until parser is reused
+	return &Session{
+		TokenHash: string(v.GetStringBytes("token_hash")),
+		UserId:    string(v.GetStringBytes("user_id")),
+		Created:   created,
+		Expires:   expires,
+	}, nil
+}
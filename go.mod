@@ -1,6 +1,6 @@
 module tic-tac-toe
 
-go 1.15
+go 1.16
 
 require (
 	github.com/fasthttp/router v1.3.2
@@ -9,5 +9,7 @@ require (
 	github.com/sirupsen/logrus v1.7.0
 	github.com/valyala/fasthttp v1.17.0
 	github.com/valyala/fastjson v1.6.1
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	gopkg.in/check.v1 v1.0.0-20200902074654-038fdea0a05b // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -2,61 +2,69 @@ package main
 
 import (
 	"bufio"
-	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
-	"strconv"
+	"github.com/valyala/fastjson"
 	"tic-tac-toe/game"
 )
 
+var arenaPool fastjson.ArenaPool // reuse arenas to build json responses
+
 func (ws *webServer) getAllGames(ctx *fasthttp.RequestCtx) {
-	logger := ws.Log.WithFields(logrus.Fields{"req": strconv.FormatUint(ctx.ID(), 26), "f": "getAllGames"})
+	logger := ws.requestLog(ctx, "getAllGames")
 
-	games, err := ws.storage.ListRaw()
+	games, err := ws.storage.List()
 	if err != nil {
 		logger.Errorln(err)
-		ctx.SetStatusCode(err.(*game.GameError).Status)
+		setProblem(ctx, err)
+		return
+	}
+	games = ownGames(ctx, games)
+
+	if notModified(ctx, gameETag(games...), ws.listModified(games)) {
 		return
 	}
+	streamGames(ctx, logger, games, (*game.Game).Marshal)
+}
 
+// stream json array of games
+func streamGames(ctx *fasthttp.RequestCtx, logger *logrus.Entry, games []*game.Game, marshal func(g *game.Game) []byte) {
 	ctx.SetContentType(applicationJson)
 	ctx.SetStatusCode(fasthttp.StatusOK)
 	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
 		_, err := w.Write([]byte{'['})
 		if err != nil {
 			logger.Errorln("can't write response:", err)
-			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 			return
 		}
 
 		for idx, g := range games {
-			_, err = w.Write(g)
+			if idx > 0 {
+				_, err = w.Write([]byte{','})
+			}
+			if err == nil {
+				_, err = w.Write(marshal(g))
+			}
 			if err != nil {
 				logger.Errorln("can't write response:", err)
-				ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 				return
 			}
-			if idx < len(games)-1 {
-				_, err = w.Write([]byte{','})
-			}
 		}
 		_, err = w.Write([]byte{']'})
 		if err != nil {
 			logger.Errorln("can't write response:", err)
-			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 			return
 		}
 		err = w.Flush()
 		if err != nil {
 			logger.Errorln("can't flush response:", err)
-			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 			return
 		}
 	})
 }
 
 func (ws *webServer) startNewGame(ctx *fasthttp.RequestCtx) {
-	logger := ws.Log.WithFields(logrus.Fields{"req": strconv.FormatUint(ctx.ID(), 26), "f": "startNewGame"})
+	logger := ws.requestLog(ctx, "startNewGame")
 
 	body := ctx.Request.Body()
 	logger.Debugln("BODY:", string(body))
@@ -65,20 +73,36 @@ func (ws *webServer) startNewGame(ctx *fasthttp.RequestCtx) {
 	val, err := p.ParseBytes(body)
 	if err != nil {
 		logger.Errorln("can't parse request:", err)
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err).WithCode(game.CodeInvalidRequest))
 		return
 	}
 
-	board := val.GetStringBytes("board")
-	if board == nil {
+	// copy board, it is valid until parser is reused
+	board := append([]byte(nil), val.GetStringBytes("board")...)
+	if len(board) == 0 {
 		logger.Errorln("can't parse board")
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't get board from request").WithCode(game.CodeInvalidRequest))
 		return
 	}
 	ws.parserPool.Put(p)
 
-	var g *game.Game
+	g, err := ws.createGame(ctx, logger, board, game.DifficultyEasy)
+	if err != nil {
+		setProblem(ctx, err)
+		return
+	}
+
+	// response to user with location and the game
+	ctx.Response.Header.Set(fasthttp.HeaderLocation, ws.baseURL(ctx)+"/api/v1/games/"+g.Id())
+	ctx.SetContentType(applicationJson)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetBody(g.Marshal())
+}
+
+// create game from the first board and make computer's move
+func (ws *webServer) createGame(ctx *fasthttp.RequestCtx, logger *logrus.Entry, board []byte, difficulty string) (*game.Game, error) {
 	var userSign byte
+	owner := owner(ctx)
 
 	switch game.WhoMovesFirst(board) {
 	case game.ComputerMove:
@@ -91,33 +115,44 @@ func (ws *webServer) startNewGame(ctx *fasthttp.RequestCtx) {
 		// user is playing O
 		userSign = game.OChar
 	default:
-		logger.Errorln("invalid first board: game: %+v", g)
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
-		return
+		logger.Errorf("invalid first board: %s", board)
+		return nil, game.NewGameError(fasthttp.StatusBadRequest, "invalid first board").WithCode(game.CodeInvalidBoard)
 	}
 
 	// create game and make move
-	g = game.NewGame(board, userSign)
+	g := game.NewGame(board, userSign)
+	if err := g.SetDifficulty(difficulty); err != nil {
+		logger.Errorln("invalid difficulty:", err)
+		return nil, err
+	}
+	g.SetOwner(owner)
+	if owner == "" {
+		// anonymous games are counted by client address
+		g.SetCreator(clientIP(ctx))
+	}
 	g.MakeMove()
 
-	logger.Debugln("game: %+v", g)
+	logger.Debugf("game: %+v", g)
+
+	if err := ws.running.reserve(gameClient(g), ws.maxRunningGames); err != nil {
+		logger.Errorln("can't start game:", err)
+		return nil, err
+	}
 
 	// save game
-	err = ws.storage.Save(g)
-	if err != nil {
+	if err := ws.storage.Save(g); err != nil {
 		logger.Errorln("can't save new game:", err)
-		ctx.SetStatusCode(err.(*game.GameError).Status)
-		return
+		ws.countRunning(g, -1)
+		return nil, err
 	}
-
-	// response to user with location
-	ctx.SetContentType(applicationJson)
-	ctx.SetStatusCode(fasthttp.StatusCreated)
-	ctx.SetBody([]byte(`{"location":"https://` + ws.Addr + `/api/v1/games/` + g.Id() + `"}`))
+	e := newAuditEntry(ctx, auditCreate, g.Id())
+	e.After = g.MarshalRecord()
+	ws.audit(e)
+	return g, nil
 }
 
 func (ws *webServer) getGame(ctx *fasthttp.RequestCtx) {
-	logger := ws.Log.WithFields(logrus.Fields{"req": strconv.FormatUint(ctx.ID(), 26), "f": "getGame"})
+	logger := ws.requestLog(ctx, "getGame")
 
 	gameId := ctx.UserValue("game_id").(string)
 	logger.Debugln("game_id:", gameId)
@@ -125,66 +160,77 @@ func (ws *webServer) getGame(ctx *fasthttp.RequestCtx) {
 
 	if !ws.storage.IsValidGameId(gameId) {
 		logger.Errorln("getGame: invalid game id", gameId)
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
 		return
 	}
 
-	g, err := ws.storage.GetRaw(gameId)
+	g, err := ws.storage.Get(gameId)
+	if err == nil {
+		err = checkOwner(ctx, g)
+	}
 	if err != nil {
 		logger.Errorln("getGame:", err)
-		ctx.SetStatusCode(err.(*game.GameError).Status)
+		setProblem(ctx, err)
 		return
 	}
 
-	setOkResponse(ctx, g)
+	if notModified(ctx, gameETag(g), g.Updated()) {
+		return
+	}
+	setOkResponse(ctx, g.Marshal())
 }
 
 func (ws *webServer) makeMove(ctx *fasthttp.RequestCtx) {
-	logger := ws.Log.WithFields(logrus.Fields{"req": strconv.FormatUint(ctx.ID(), 26), "f": "makeMove"})
+	logger := ws.requestLog(ctx, "makeMove")
 	gameId := ctx.UserValue("game_id").(string)
 	logger.Debugln("game_id:", gameId)
 
 	if !ws.storage.IsValidGameId(gameId) {
 		logger.Errorln("invalid game id", gameId)
-		setReason(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id"))
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
 		return
 	}
 
-	p := ws.parserPool.Get()
-	val, err := p.ParseBytes(ctx.Request.Body())
+	move, err := ws.parseMove(ctx.Request.Body())
 	if err != nil {
 		logger.Errorln("makeMove: can't parse request:", err)
-		setReason(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err))
+		setProblem(ctx, err)
 		return
 	}
 
-	board := val.GetStringBytes("board")
-	if board == nil {
-		logger.Errorln("makeMove: can't parse board")
-		setReason(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't get board from request"))
+	g, err := ws.playMove(ctx, logger, gameId, move)
+	if err != nil {
+		setProblem(ctx, err)
 		return
 	}
-	ws.parserPool.Put(p)
 
+	// marshal game and send to user
+	setOkResponse(ctx, g.Marshal())
+}
+
+// apply user's move and make computer's one
+func (ws *webServer) playMove(ctx *fasthttp.RequestCtx, logger *logrus.Entry, gameId string, move *userMove) (*game.Game, error) {
 	g, err := ws.storage.Get(gameId)
+	if err == nil {
+		err = checkOwner(ctx, g)
+	}
 	if err != nil {
 		logger.Errorln("makeMove:", err)
-		setReason(ctx, err.(*game.GameError))
-		return
+		return nil, err
 	}
+	e := newAuditEntry(ctx, auditMove, gameId)
+	e.Before = g.MarshalRecord()
 
 	// check game status
 	if g.Status() != game.RUNNING {
 		logger.Errorln("makeMove: game already finished with status", g.Status())
-		setReason(ctx, game.NewGameError(fasthttp.StatusBadRequest, "game already finished with status "+g.Status()))
-		return
+		return nil, game.NewGameError(fasthttp.StatusBadRequest, "game already finished with status "+g.Status()).WithCode(game.CodeGameFinished)
 	}
 
 	// validate user move
-	if ok, err := g.SetNewBoard(board); !ok {
-		logger.Errorln("makeMove: board is invalid")
-		setReason(ctx, err.(*game.GameError))
-		return
+	if err = move.apply(g); err != nil {
+		logger.Errorln("makeMove: move is invalid:", err)
+		return nil, err
 	}
 
 	// check is user a winner?
@@ -199,43 +245,215 @@ func (ws *webServer) makeMove(ctx *fasthttp.RequestCtx) {
 	err = ws.storage.Save(g)
 	if err != nil {
 		logger.Errorln("makeMove: can't save game:", err)
-		setReason(ctx, err.(*game.GameError))
-		return
+		return nil, err
+	}
+	if g.Status() != game.RUNNING {
+		ws.running.add(gameClient(g), -1)
 	}
+	e.After = g.MarshalRecord()
+	ws.audit(e)
+	return g, nil
+}
 
-	// marshal game and send to user
-	setOkResponse(ctx, g.Marshal())
+// user's move: full board or single cell
+type userMove struct {
+	board []byte
+	cell  int
+}
+
+// parse move request: `{"board":"X---O----"}`, `{"cell":4}` or `{"row":1,"col":1}`
+func (ws *webServer) parseMove(body []byte) (*userMove, error) {
+	p := ws.parserPool.Get()
+	defer ws.parserPool.Put(p)
+
+	val, err := p.ParseBytes(body)
+	if err != nil {
+		return nil, game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err).WithCode(game.CodeInvalidRequest)
+	}
+
+	switch {
+	case val.Exists("board"):
+		board := val.GetStringBytes("board")
+		if board == nil {
+			return nil, game.NewGameError(fasthttp.StatusBadRequest, "board must be a string").WithCode(game.CodeInvalidRequest)
+		}
+		// copy board, it is valid until parser is reused
+		return &userMove{board: append([]byte(nil), board...)}, nil
+
+	case val.Exists("cell"):
+		cell, err := val.Get("cell").Int()
+		if err != nil {
+			return nil, game.NewGameError(fasthttp.StatusBadRequest, "cell must be an integer").WithCode(game.CodeInvalidRequest)
+		}
+		return &userMove{cell: cell}, nil
+
+	case val.Exists("row") && val.Exists("col"):
+		row, rErr := val.Get("row").Int()
+		col, cErr := val.Get("col").Int()
+		if rErr != nil || cErr != nil {
+			return nil, game.NewGameError(fasthttp.StatusBadRequest, "row and col must be integers").WithCode(game.CodeInvalidRequest)
+		}
+		if row < 0 || row > 2 || col < 0 || col > 2 {
+			return nil, game.NewGameError(fasthttp.StatusBadRequest, "row and col must be in range 0-2").WithCode(game.CodeInvalidMove)
+		}
+		return &userMove{cell: row*3 + col}, nil
+	}
+
+	return nil, game.NewGameError(fasthttp.StatusBadRequest, "request must contain board, cell or row and col").WithCode(game.CodeInvalidRequest)
+}
+
+// validate and apply move to the game
+func (m *userMove) apply(g *game.Game) error {
+	if m.board != nil {
+		_, err := g.SetNewBoard(m.board)
+		return err
+	}
+	return g.PlaceSign(m.cell)
 }
 
 func (ws *webServer) deleteGame(ctx *fasthttp.RequestCtx) {
-	logger := ws.Log.WithFields(logrus.Fields{"req": strconv.FormatUint(ctx.ID(), 26), "f": "deleteGame"})
+	logger := ws.requestLog(ctx, "deleteGame")
 	gameId := ctx.UserValue("game_id").(string)
 	logger.Debugln("game_id:", gameId)
 
 	if !ws.storage.IsValidGameId(gameId) {
 		logger.Errorln("invalid game id", gameId)
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
 		return
 	}
 
-	err := ws.storage.Delete(gameId)
+	// only admins delete games, so owner isn't checked
+	g, err := ws.storage.Get(gameId)
+	if err == nil {
+		err = ws.storage.Delete(gameId)
+	}
 	if err != nil {
 		logger.Errorln(err)
-		ctx.SetStatusCode(err.(*game.GameError).Status)
+		setProblem(ctx, err)
 		return
 	}
+	ws.touchList()
+	ws.countRunning(g, -1)
+
+	e := newAuditEntry(ctx, auditDelete, gameId)
+	e.Before = g.MarshalRecord()
+	ws.audit(e)
 
 	setOkResponse(ctx, nil)
 }
 
-func setReason(ctx *fasthttp.RequestCtx, err *game.GameError) {
-	ctx.SetStatusCode(err.Status)
-	ctx.SetContentType(applicationJson)
-	if err.Status == fasthttp.StatusInternalServerError {
-		ctx.SetBody([]byte(`{"reason":"internal server error"}`))
-	} else {
-		ctx.SetBody([]byte(fmt.Sprintf(`{"reason":%q}`, err.Error())))
+func (ws *webServer) getDeletedGames(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getDeletedGames")
+
+	games, err := ws.storage.ListDeleted()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+
+	res := []byte{'['}
+	for _, g := range games {
+		if !listsGame(ctx, g.Game) {
+			continue
+		}
+		if len(res) > 1 {
+			res = append(res, ',')
+		}
+		res = append(res, g.Marshal()...)
+	}
+	res = append(res, ']')
+
+	setOkResponse(ctx, res)
+}
+
+// finished games archived by batch action
+func (ws *webServer) getArchivedGames(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getArchivedGames")
+
+	games, err := ws.storage.ListArchived()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	streamGames(ctx, logger, ownGames(ctx, games), (*game.Game).Marshal)
+}
+
+func (ws *webServer) restoreGame(ctx *fasthttp.RequestCtx) {
+	ws.undeleteGame(ctx, "restoreGame", func(g *game.Game) error {
+		return checkOwner(ctx, g)
+	})
+}
+
+// move game back from trash if check allows it, nil check allows any game
+func (ws *webServer) undeleteGame(ctx *fasthttp.RequestCtx, f string, check func(g *game.Game) error) {
+	logger := ws.requestLog(ctx, f)
+	gameId := ctx.UserValue("game_id").(string)
+	logger.Debugln("game_id:", gameId)
+
+	if !ws.storage.IsValidGameId(gameId) {
+		logger.Errorln("invalid game id", gameId)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
+		return
+	}
+
+	d, err := ws.storage.GetDeleted(gameId)
+	if err == nil && check != nil {
+		err = check(d.Game)
+	}
+	if err == nil {
+		err = ws.storage.Undelete(gameId)
+	}
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
 	}
+	logger.Infoln("game restored:", gameId)
+	ws.touchList()
+
+	g, err := ws.storage.Get(gameId)
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+
+	ws.countRunning(g, 1)
+
+	e := newAuditEntry(ctx, auditRestore, gameId)
+	e.Before, e.After = d.Game.MarshalRecord(), g.MarshalRecord()
+	ws.audit(e)
+
+	setOkResponse(ctx, g.Marshal())
+}
+
+// write RFC 7807 problem details, internal errors are not exposed to client
+func setProblem(ctx *fasthttp.RequestCtx, err error) {
+	gErr := game.AsGameError(err)
+
+	detail := gErr.Error()
+	if gErr.Status >= fasthttp.StatusInternalServerError {
+		detail = "internal server error"
+	}
+
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	problem := a.NewObject()
+	problem.Set("type", a.NewString(problemTypePrefix+gErr.Code()))
+	problem.Set("title", a.NewString(fasthttp.StatusMessage(gErr.Status)))
+	problem.Set("status", a.NewNumberInt(gErr.Status))
+	problem.Set("detail", a.NewString(detail))
+	problem.Set("instance", a.NewStringBytes(ctx.Path()))
+	problem.Set("code", a.NewString(gErr.Code()))
+	// `reason` is kept for clients of previous API versions
+	problem.Set("reason", a.NewString(detail))
+
+	ctx.SetStatusCode(gErr.Status)
+	ctx.SetContentType(applicationProblemJson)
+	ctx.SetBody(problem.MarshalTo(nil))
 }
 
 func setOkResponse(ctx *fasthttp.RequestCtx, res []byte) {
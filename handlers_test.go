@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartNewGame_Location(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.idempotencyWindow = time.Hour
+	})
+	if ws.listenURL != "https://127.0.0.1:0" {
+		t.Fatalf("got listen URL %q", ws.listenURL)
+	}
+
+	// Host header is set by client and isn't used in links
+	resp := doRequest(ws, "POST", "/api/v1/games", `{"board":"---------"}`, "Host", "evil.com", idempotencyKeyHeader, "key-1")
+	if resp.StatusCode() != fasthttp.StatusCreated {
+		t.Fatalf("can't start game: %d %s", resp.StatusCode(), resp.Body())
+	}
+	location := string(resp.Header.Peek(fasthttp.HeaderLocation))
+	if !strings.HasPrefix(location, ws.listenURL+"/api/v1/games/") {
+		t.Fatalf("got Location %q", location)
+	}
+
+	// replayed response has the same link for other host
+	resp = doRequest(ws, "POST", "/api/v1/games", `{"board":"---------"}`, "Host", "localhost", idempotencyKeyHeader, "key-1")
+	if v := string(resp.Header.Peek(fasthttp.HeaderLocation)); v != location {
+		t.Fatalf("got replayed Location %q, expected %q", v, location)
+	}
+
+	// forwarded host is taken from trusted proxies only
+	resp = doRequest(ws, "POST", "/api/v1/games", `{"board":"---------"}`, headerForwardedHost, "evil.com")
+	if v := string(resp.Header.Peek(fasthttp.HeaderLocation)); !strings.HasPrefix(v, ws.listenURL+"/api/v1/games/") {
+		t.Fatalf("got Location %q for untrusted forwarded host", v)
+	}
+	ws.proxies, _ = parseTrustedProxies("127.0.0.1")
+	resp = doRequest(ws, "POST", "/api/v1/games", `{"board":"---------"}`, headerForwardedHost, "games.example.org", headerForwardedProto, "https")
+	if v := string(resp.Header.Peek(fasthttp.HeaderLocation)); !strings.HasPrefix(v, "https://games.example.org/api/v1/games/") {
+		t.Fatalf("got Location %q for forwarded host", v)
+	}
+
+	ws.publicURL = "https://games.example.com"
+	resp = doRequest(ws, "POST", "/api/v1/games", `{"board":"---------"}`, "Host", "evil.com", headerForwardedHost, "games.example.org")
+	if v := string(resp.Header.Peek(fasthttp.HeaderLocation)); !strings.HasPrefix(v, "https://games.example.com/api/v1/games/") {
+		t.Fatalf("got Location %q for public URL", v)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"tic-tac-toe/game"
+	"time"
+)
+
+const emptyBoard = "---------"
+
+// render v2 game resource, links are absolute and based on baseURL
+func marshalGameV2(g *game.Game, baseURL string) []byte {
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	o := a.NewObject()
+	o.Set("id", a.NewString(g.Id()))
+	o.Set("board", a.NewStringBytes(g.Board()))
+	o.Set("status", a.NewString(g.Status()))
+	o.Set("created_at", timeValue(a, g.Created()))
+	o.Set("updated_at", timeValue(a, g.Updated()))
+	o.Set("difficulty", a.NewString(g.Difficulty()))
+
+	user := a.NewObject()
+	user.Set("sign", a.NewString(string(g.UserSign())))
+	computer := a.NewObject()
+	computer.Set("sign", a.NewString(string(g.CompSign())))
+	players := a.NewObject()
+	players.Set(game.PlayerUser, user)
+	players.Set(game.PlayerComputer, computer)
+	o.Set("players", players)
+
+	// computer moves right after user, so in running game it's always user's turn
+	if g.Status() == game.RUNNING {
+		o.Set("next", a.NewString(game.PlayerUser))
+	} else {
+		o.Set("next", a.NewNull())
+	}
+
+	moves := a.NewArray()
+	for idx, m := range g.Moves() {
+		mv := a.NewObject()
+		mv.Set("number", a.NewNumberInt(idx+1))
+		mv.Set("cell", a.NewNumberInt(m.Cell))
+		mv.Set("row", a.NewNumberInt(m.Cell/3))
+		mv.Set("col", a.NewNumberInt(m.Cell%3))
+		mv.Set("sign", a.NewString(string(m.Sign)))
+		mv.Set("player", a.NewString(m.Player))
+		mv.Set("at", timeValue(a, m.At))
+		moves.SetArrayItem(idx, mv)
+	}
+	o.Set("moves", moves)
+
+	if line := g.WinningLine(); line != nil {
+		cells := a.NewArray()
+		for idx, c := range line {
+			cells.SetArrayItem(idx, a.NewNumberInt(c))
+		}
+		o.Set("winning_line", cells)
+	} else {
+		o.Set("winning_line", a.NewNull())
+	}
+
+	self := baseURL + "/api/v2/games/" + g.Id()
+	links := a.NewObject()
+	links.Set("self", a.NewString(self))
+	links.Set("moves", a.NewString(self+"/moves"))
+	links.Set("v1", a.NewString(baseURL+"/api/v1/games/"+g.Id()))
+	o.Set("links", links)
+
+	return o.MarshalTo(nil)
+}
+
+// games saved before timestamps were tracked have zero time, it's rendered as null
+func timeValue(a *fastjson.Arena, t time.Time) *fastjson.Value {
+	if t.IsZero() {
+		return a.NewNull()
+	}
+	return a.NewString(t.Format(time.RFC3339))
+}
+
+func (ws *webServer) getAllGamesV2(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getAllGamesV2")
+
+	games, err := ws.storage.List()
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+	games = ownGames(ctx, games)
+
+	if notModified(ctx, gameETag(games...), ws.listModified(games)) {
+		return
+	}
+	baseURL := ws.baseURL(ctx)
+	streamGames(ctx, logger, games, func(g *game.Game) []byte {
+		return marshalGameV2(g, baseURL)
+	})
+}
+
+func (ws *webServer) startNewGameV2(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "startNewGameV2")
+
+	body := ctx.Request.Body()
+	logger.Debugln("BODY:", string(body))
+
+	// board and difficulty are optional, computer starts the game on empty board
+	board := []byte(emptyBoard)
+	difficulty := game.DifficultyEasy
+	if len(body) > 0 {
+		p := ws.parserPool.Get()
+		val, err := p.ParseBytes(body)
+		if err != nil {
+			ws.parserPool.Put(p)
+			logger.Errorln("can't parse request:", err)
+			setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't parse request", err).WithCode(game.CodeInvalidRequest))
+			return
+		}
+		// copy values, they are valid until parser is reused
+		if val.Exists("board") {
+			board = append([]byte(nil), val.GetStringBytes("board")...)
+		}
+		if val.Exists("difficulty") {
+			difficulty = string(val.GetStringBytes("difficulty"))
+		}
+		ws.parserPool.Put(p)
+	}
+
+	g, err := ws.createGame(ctx, logger, board, difficulty)
+	if err != nil {
+		setProblem(ctx, err)
+		return
+	}
+
+	baseURL := ws.baseURL(ctx)
+	ctx.Response.Header.Set(fasthttp.HeaderLocation, baseURL+"/api/v2/games/"+g.Id())
+	ctx.SetContentType(applicationJson)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetBody(marshalGameV2(g, baseURL))
+}
+
+func (ws *webServer) getGameV2(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "getGameV2")
+	gameId := ctx.UserValue("game_id").(string)
+	logger.Debugln("game_id:", gameId)
+
+	if !ws.storage.IsValidGameId(gameId) {
+		logger.Errorln("invalid game id", gameId)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
+		return
+	}
+
+	g, err := ws.storage.Get(gameId)
+	if err == nil {
+		err = checkOwner(ctx, g)
+	}
+	if err != nil {
+		logger.Errorln(err)
+		setProblem(ctx, err)
+		return
+	}
+
+	if notModified(ctx, gameETag(g), g.Updated()) {
+		return
+	}
+	setOkResponse(ctx, marshalGameV2(g, ws.baseURL(ctx)))
+}
+
+func (ws *webServer) makeMoveV2(ctx *fasthttp.RequestCtx) {
+	logger := ws.requestLog(ctx, "makeMoveV2")
+	gameId := ctx.UserValue("game_id").(string)
+	logger.Debugln("game_id:", gameId)
+
+	if !ws.storage.IsValidGameId(gameId) {
+		logger.Errorln("invalid game id", gameId)
+		setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "invalid game id").WithCode(game.CodeInvalidGameId))
+		return
+	}
+
+	move, err := ws.parseMove(ctx.Request.Body())
+	if err != nil {
+		logger.Errorln("can't parse request:", err)
+		setProblem(ctx, err)
+		return
+	}
+
+	g, err := ws.playMove(ctx, logger, gameId, move)
+	if err != nil {
+		setProblem(ctx, err)
+		return
+	}
+
+	setOkResponse(ctx, marshalGameV2(g, ws.baseURL(ctx)))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/valyala/fasthttp"
+	"tic-tac-toe/game"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// replay saved response for repeated request with the same `Idempotency-Key`. Only successful
+// responses are saved, so failed requests could be retried with the same key
+func (ws *webServer) Idempotent(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	fn := func(ctx *fasthttp.RequestCtx) {
+		key := string(ctx.Request.Header.Peek(idempotencyKeyHeader))
+		if key == "" || ws.idempotencyWindow <= 0 {
+			next(ctx)
+			return
+		}
+
+		logger := ws.requestLog(ctx, "Idempotent")
+		if len(key) > maxIdempotencyKeyLength {
+			setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "idempotency key is too long").WithCode(game.CodeInvalidRequest))
+			return
+		}
+		fingerprint := requestFingerprint(ctx)
+
+		// keys are scoped by client, so clients can't see responses of each other
+		if id := identity(ctx); id != nil {
+			key = id.Subject + " " + key
+		}
+
+		// concurrent requests with the same key must not be executed twice
+		if _, busy := ws.inFlight.LoadOrStore(key, struct{}{}); busy {
+			logger.Errorln("request with the same idempotency key is in progress:", key)
+			setProblem(ctx, game.NewGameError(fasthttp.StatusConflict, "request with the same idempotency key is in progress").WithCode(game.CodeRequestInProgress))
+			return
+		}
+		defer ws.inFlight.Delete(key)
+
+		saved, err := ws.storage.GetIdempotentResponse(key)
+		if err == nil && time.Since(saved.Created) < ws.idempotencyWindow {
+			if saved.Fingerprint != fingerprint {
+				logger.Errorln("idempotency key is reused for another request:", key)
+				setProblem(ctx, game.NewGameError(fasthttp.StatusUnprocessableEntity, "idempotency key is already used for another request").WithCode(game.CodeIdempotencyKeyReused))
+				return
+			}
+
+			logger.Infoln("replay response for idempotency key", key)
+			if saved.Location != "" {
+				ctx.Response.Header.Set(fasthttp.HeaderLocation, saved.Location)
+			}
+			ctx.Response.Header.Set(idempotentReplayedHeader, "true")
+			ctx.SetContentType(saved.ContentType)
+			ctx.SetStatusCode(saved.Status)
+			ctx.SetBody(saved.Body)
+			return
+		}
+		if err != nil && game.AsGameError(err).Status != fasthttp.StatusNotFound {
+			logger.Errorln("can't get saved response:", err)
+			setProblem(ctx, err)
+			return
+		}
+
+		next(ctx)
+
+		status := ctx.Response.StatusCode()
+		if status < fasthttp.StatusOK || status >= fasthttp.StatusMultipleChoices {
+			return
+		}
+		err = ws.storage.SaveIdempotentResponse(&game.IdempotentResponse{
+			Key:         key,
+			Fingerprint: fingerprint,
+			Status:      status,
+			ContentType: string(ctx.Response.Header.ContentType()),
+			Location:    string(ctx.Response.Header.Peek(fasthttp.HeaderLocation)),
+			Body:        append([]byte(nil), ctx.Response.Body()...),
+			Created:     time.Now(),
+		})
+		if err != nil {
+			// the request is done anyway, retry will be executed again
+			logger.Errorln("can't save response:", err)
+		}
+	}
+	return fn
+}
+
+// hash of method, path and body to detect key reuse for another request
+func requestFingerprint(ctx *fasthttp.RequestCtx) string {
+	h := sha256.New()
+	h.Write(ctx.Method())
+	h.Write([]byte{' '})
+	h.Write(ctx.Path())
+	h.Write([]byte{'\n'})
+	h.Write(ctx.Request.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
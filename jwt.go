@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hmacKeyPrefix = "hmac:" // key file is HMAC secret
+
+	jwtHS256  = "HS256"
+	jwtRS256  = "RS256"
+	jwtEdDSA  = "EdDSA"
+	jwtLeeway = time.Minute // allowed clock skew for exp and nbf
+)
+
+// key to verify token signatures
+type jwtKey struct {
+	id  string // `kid` of key, tokens without `kid` and keys without id match any key
+	alg string
+	key interface{} // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// validates signed JWTs, keys are configured locally so there are no requests to identity provider
+type jwtVerifier struct {
+	keys     []*jwtKey
+	issuer   string // expected `iss`, not checked if empty
+	audience string // expected `aud`, not checked if empty
+}
+
+// load key file: PEM encoded RSA or Ed25519 public key or certificate, or HMAC secret if file has
+// `hmac:` prefix. Key id is set by `kid=` prefix, e.g. `kid=sso-2024=hmac:ssl/hmac.secret`
+func (v *jwtVerifier) loadKeyFile(spec string) error {
+	var id string
+	if strings.HasPrefix(spec, "kid=") {
+		idx := strings.IndexByte(spec[4:], '=')
+		if idx <= 0 {
+			return errors.New(spec + ": expected kid=<id>=<file>")
+		}
+		id, spec = spec[4:4+idx], spec[5+idx:]
+	}
+	fname := strings.TrimPrefix(spec, hmacKeyPrefix)
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(spec, hmacKeyPrefix) {
+		secret := bytes.TrimSpace(data)
+		if len(secret) < 32 {
+			return errors.New(fname + ": HMAC secret must be at least 32 bytes")
+		}
+		v.keys = append(v.keys, &jwtKey{id: id, alg: jwtHS256, key: secret})
+		return nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New(fname + ": PEM key is expected, HMAC secret must be set as " + hmacKeyPrefix + fname)
+	}
+
+	var pub interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	default:
+		return errors.New(fname + ": unsupported PEM block " + block.Type)
+	}
+	if err != nil {
+		return errors.New(fname + ": " + err.Error())
+	}
+	return v.addPublicKey(id, pub, fname)
+}
+
+func (v *jwtVerifier) addPublicKey(id string, pub interface{}, source string) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return errors.New(source + ": RSA key must be at least 2048 bits")
+		}
+		v.keys = append(v.keys, &jwtKey{id: id, alg: jwtRS256, key: k})
+	case ed25519.PublicKey:
+		v.keys = append(v.keys, &jwtKey{id: id, alg: jwtEdDSA, key: k})
+	default:
+		return errors.New(source + ": unsupported public key type")
+	}
+	return nil
+}
+
+// load keys from JWKS file. Keys for encryption and of unsupported types are skipped
+func (v *jwtVerifier) loadJWKS(fname string) error {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	val, err := fastjson.ParseBytes(data)
+	if err != nil {
+		return errors.New(fname + ": " + err.Error())
+	}
+
+	loaded := 0
+	for idx, k := range val.GetArray("keys") {
+		if use := string(k.GetStringBytes("use")); use != "" && use != "sig" {
+			continue
+		}
+		id := string(k.GetStringBytes("kid"))
+		source := fname + ": key " + id
+		if id == "" {
+			source = fname + ": key #" + strconv.Itoa(idx)
+		}
+
+		switch string(k.GetStringBytes("kty")) {
+		case "oct":
+			secret, err := jwkBytes(k, "k")
+			if err != nil || len(secret) < 32 {
+				return errors.New(source + ": HMAC secret must be at least 32 bytes")
+			}
+			v.keys = append(v.keys, &jwtKey{id: id, alg: jwtHS256, key: secret})
+		case "RSA":
+			n, nErr := jwkBytes(k, "n")
+			e, eErr := jwkBytes(k, "e")
+			if nErr != nil || eErr != nil || len(e) == 0 || len(e) > 4 {
+				return errors.New(source + ": invalid RSA key")
+			}
+			pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			if err = v.addPublicKey(id, pub, source); err != nil {
+				return err
+			}
+		case "OKP":
+			x, err := jwkBytes(k, "x")
+			if string(k.GetStringBytes("crv")) != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+				return errors.New(source + ": invalid Ed25519 key")
+			}
+			v.keys = append(v.keys, &jwtKey{id: id, alg: jwtEdDSA, key: ed25519.PublicKey(x)})
+		default:
+			continue
+		}
+		loaded++
+	}
+	if loaded == 0 {
+		return errors.New(fname + ": no signature keys")
+	}
+	return nil
+}
+
+func jwkBytes(k *fastjson.Value, name string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(string(k.GetStringBytes(name)))
+}
+
+// authenticate client by `Authorization: Bearer <jwt>` header
+func (v *jwtVerifier) authenticate(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	auth := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return nil, nil
+	}
+
+	sub, err := v.verify(strings.TrimSpace(auth[7:]), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Subject: "jwt:" + sub, Method: "jwt"}, nil
+}
+
+// check token signature and claims, subject is returned
+func (v *jwtVerifier) verify(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+
+	header, err := decodeJWTPart(parts[0])
+	if err != nil {
+		return "", errors.New("malformed token header")
+	}
+	alg, kid := string(header.GetStringBytes("alg")), string(header.GetStringBytes("kid"))
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed token signature")
+	}
+	signed := []byte(token[:len(parts[0])+1+len(parts[1])])
+
+	// algorithm is taken from key, so token can't choose weaker verification
+	valid := false
+	for _, k := range v.keys {
+		if k.alg != alg || (kid != "" && k.id != "" && k.id != kid) {
+			continue
+		}
+		if valid = k.verify(signed, sig); valid {
+			break
+		}
+	}
+	if !valid {
+		return "", errors.New("invalid token signature")
+	}
+
+	claims, err := decodeJWTPart(parts[1])
+	if err != nil {
+		return "", errors.New("malformed token claims")
+	}
+
+	exp := claims.Get("exp")
+	if exp == nil {
+		return "", errors.New("token has no expiration time")
+	}
+	if t, err := exp.Float64(); err != nil || now.Add(-jwtLeeway).After(time.Unix(int64(t), 0)) {
+		return "", errors.New("token is expired")
+	}
+	if nbf := claims.Get("nbf"); nbf != nil {
+		if t, err := nbf.Float64(); err != nil || now.Add(jwtLeeway).Before(time.Unix(int64(t), 0)) {
+			return "", errors.New("token is not valid yet")
+		}
+	}
+	if v.issuer != "" && string(claims.GetStringBytes("iss")) != v.issuer {
+		return "", errors.New("unexpected token issuer")
+	}
+	if v.audience != "" && !hasAudience(claims.Get("aud"), v.audience) {
+		return "", errors.New("token is issued for another audience")
+	}
+
+	sub := string(claims.GetStringBytes("sub"))
+	if sub == "" {
+		return "", errors.New("token has no subject")
+	}
+	return sub, nil
+}
+
+func (k *jwtKey) verify(signed, sig []byte) bool {
+	switch key := k.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, signed, sig)
+	}
+	return false
+}
+
+// decode base64url encoded json object, returned value doesn't depend on parser
+func decodeJWTPart(part string) (*fastjson.Value, error) {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return nil, err
+	}
+	v, err := fastjson.ParseBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if v.Type() != fastjson.TypeObject {
+		return nil, errors.New("object expected")
+	}
+	return v, nil
+}
+
+// `aud` claim is either a string or an array of strings
+func hasAudience(aud *fastjson.Value, audience string) bool {
+	if aud == nil {
+		return false
+	}
+	if aud.Type() == fastjson.TypeString {
+		return string(aud.GetStringBytes()) == audience
+	}
+	for _, item := range aud.GetArray() {
+		if string(item.GetStringBytes()) == audience {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// sign token with HMAC secret or Ed25519 key by alg of header
+func signJWT(t *testing.T, header, claims string, key interface{}) string {
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(claims))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func unsignedJWT(header, claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(claims)) + "."
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &jwtVerifier{
+		keys: []*jwtKey{
+			{id: "hs", alg: jwtHS256, key: testHMACSecret},
+			{id: "ed", alg: jwtEdDSA, key: pub},
+		},
+		issuer:   "https://sso.example.com",
+		audience: "tic-tac-toe",
+	}
+	now := time.Unix(1600000000, 0)
+
+	const (
+		hs    = `{"alg":"HS256","typ":"JWT"}`
+		hsKid = `{"alg":"HS256","kid":"hs"}`
+		ed    = `{"alg":"EdDSA","kid":"ed"}`
+	)
+	claims := func(extra string) string {
+		return `{"sub":"alice","iss":"https://sso.example.com","aud":"tic-tac-toe","exp":1600000600` + extra + `}`
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"valid HMAC", signJWT(t, hs, claims(""), testHMACSecret), ""},
+		{"valid HMAC with kid", signJWT(t, hsKid, claims(""), testHMACSecret), ""},
+		{"valid EdDSA", signJWT(t, ed, claims(""), priv), ""},
+		{"audience in array", signJWT(t, hs, `{"sub":"alice","iss":"https://sso.example.com","aud":["x","tic-tac-toe"],"exp":1600000600}`, testHMACSecret), ""},
+		{"expired", signJWT(t, hs, `{"sub":"alice","iss":"https://sso.example.com","aud":"tic-tac-toe","exp":1599999900}`, testHMACSecret), "token is expired"},
+		{"expired within leeway", signJWT(t, hs, `{"sub":"alice","iss":"https://sso.example.com","aud":"tic-tac-toe","exp":1599999970}`, testHMACSecret), ""},
+		{"no expiration", signJWT(t, hs, `{"sub":"alice","iss":"https://sso.example.com","aud":"tic-tac-toe"}`, testHMACSecret), "token has no expiration time"},
+		{"not valid yet", signJWT(t, hs, claims(`,"nbf":1600000300`), testHMACSecret), "token is not valid yet"},
+		{"nbf within leeway", signJWT(t, hs, claims(`,"nbf":1600000030`), testHMACSecret), ""},
+		{"other issuer", signJWT(t, hs, `{"sub":"alice","iss":"https://evil.com","aud":"tic-tac-toe","exp":1600000600}`, testHMACSecret), "unexpected token issuer"},
+		{"other audience", signJWT(t, hs, `{"sub":"alice","iss":"https://sso.example.com","aud":"other","exp":1600000600}`, testHMACSecret), "token is issued for another audience"},
+		{"no audience", signJWT(t, hs, `{"sub":"alice","iss":"https://sso.example.com","exp":1600000600}`, testHMACSecret), "token is issued for another audience"},
+		{"no subject", signJWT(t, hs, `{"iss":"https://sso.example.com","aud":"tic-tac-toe","exp":1600000600}`, testHMACSecret), "token has no subject"},
+		{"wrong alg", signJWT(t, `{"alg":"EdDSA"}`, claims(""), testHMACSecret), "invalid token signature"},
+		{"alg none", unsignedJWT(`{"alg":"none"}`, claims("")), "invalid token signature"},
+		{"kid mismatch", signJWT(t, `{"alg":"HS256","kid":"ed"}`, claims(""), testHMACSecret), "invalid token signature"},
+		{"unknown kid", signJWT(t, `{"alg":"EdDSA","kid":"other"}`, claims(""), priv), "invalid token signature"},
+		{"wrong key", signJWT(t, hs, claims(""), []byte("another secret of at least 32 bytes")), "invalid token signature"},
+		{"malformed", "abc.def", "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, err := v.verify(tt.token, now)
+			if tt.err == "" {
+				if err != nil || sub != "alice" {
+					t.Fatalf("got %q, %v, expected alice", sub, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestJWTVerifier_LoadKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tic-tac-toe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := filepath.Join(dir, "hmac.secret")
+	if err = ioutil.WriteFile(secret, append(testHMACSecret, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &jwtVerifier{}
+	if err = v.loadKeyFile(secret); err == nil {
+		t.Fatal("file which is not PEM is loaded as HMAC secret without prefix")
+	}
+	if err = v.loadKeyFile(hmacKeyPrefix + secret); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.loadKeyFile("kid=sso-1=" + hmacKeyPrefix + secret); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.keys) != 2 || v.keys[0].id != "" || v.keys[1].id != "sso-1" || string(v.keys[0].key.([]byte)) != string(testHMACSecret) {
+		t.Fatalf("unexpected keys %+v %+v", v.keys[0], v.keys[1])
+	}
+
+	// key without id matches token with any kid
+	v.keys = v.keys[:1]
+	token := signJWT(t, `{"alg":"HS256","kid":"rotated"}`, `{"sub":"alice","exp":1600000600}`, testHMACSecret)
+	if _, err = v.verify(token, time.Unix(1600000000, 0)); err != nil {
+		t.Fatalf("token with kid: %s", err)
+	}
+}
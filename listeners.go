@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp/reuseport"
+	"net"
+	"os"
+	"strings"
+)
+
+const unixSocketPrefix = "unix:"
+
+// address to listen to: `host:port` or `https://host:port` for TLS, `http://host:port` for plain
+// HTTP and `unix:/path/to/socket` for plain HTTP over unix socket. IPv6 hosts are in brackets,
+// e.g. `[::]:443`
+type listenAddr struct {
+	network string // tcp4, tcp6 or unix
+	addr    string
+	tls     bool
+}
+
+// parse comma separated listen addresses
+func parseListenAddrs(addrs string) ([]*listenAddr, error) {
+	var res []*listenAddr
+	for _, s := range strings.Split(addrs, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		a, err := parseListenAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no address to listen to")
+	}
+	return res, nil
+}
+
+func parseListenAddr(s string) (*listenAddr, error) {
+	if strings.HasPrefix(s, unixSocketPrefix) {
+		path := strings.TrimPrefix(s, unixSocketPrefix)
+		if path == "" {
+			return nil, errors.New(s + ": unix socket path is missing")
+		}
+		return &listenAddr{network: "unix", addr: path}, nil
+	}
+
+	a := &listenAddr{tls: true}
+	switch {
+	case strings.HasPrefix(s, "https://"):
+		a.addr = strings.TrimPrefix(s, "https://")
+	case strings.HasPrefix(s, "http://"):
+		a.addr, a.tls = strings.TrimPrefix(s, "http://"), false
+	default:
+		a.addr = s
+	}
+
+	host, _, err := net.SplitHostPort(a.addr)
+	if err != nil {
+		return nil, errors.New(s + ": " + err.Error())
+	}
+	a.network = "tcp4"
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		a.network = "tcp6"
+	}
+	return a, nil
+}
+
+func (a *listenAddr) String() string {
+	switch {
+	case a.network == "unix":
+		return unixSocketPrefix + a.addr
+	case a.tls:
+		return "https://" + a.addr
+	}
+	return "http://" + a.addr
+}
+
+// TCP listeners reuse port to run per-core server instance. Stale unix socket left by killed
+// service is removed, new socket is accessible by owner and group, e.g. by proxy
+func (a *listenAddr) listen() (net.Listener, error) {
+	if a.network != "unix" {
+		return reuseport.Listen(a.network, a.addr)
+	}
+
+	if info, err := os.Stat(a.addr); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(a.addr + " exists and is not a socket")
+		}
+		if err = os.Remove(a.addr); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", a.addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(a.addr, 0660); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// hosts of TCP addresses, they are added to generated service certificate
+func listenHosts(addrs []*listenAddr) []string {
+	var hosts []string
+	for _, a := range addrs {
+		if a.network == "unix" {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(a.addr); err == nil && host != "" && !net.ParseIP(host).IsUnspecified() {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// URL of the first TCP address, e.g. `https://localhost:8443`. Unspecified host is replaced by
+// hostname, as clients can't connect to `0.0.0.0`, and default port is omitted
+func listenURL(addrs []*listenAddr, hostname string) string {
+	for _, a := range addrs {
+		if a.network == "unix" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(a.addr)
+		if err != nil {
+			continue
+		}
+		if host == "" || net.ParseIP(host).IsUnspecified() {
+			host = hostname
+		}
+		u := &listenAddr{tls: a.tls, addr: net.JoinHostPort(host, port)}
+		if (a.tls && port == "443") || (!a.tls && port == "80") {
+			u.addr = host
+			if strings.Contains(host, ":") {
+				u.addr = "[" + host + "]"
+			}
+		}
+		return u.String()
+	}
+	return "http://localhost"
+}
+
+// name of the machine, generated cert is issued for it too
+func hostname() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return strings.ToLower(name)
+	}
+	return "localhost"
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		s       string
+		network string
+		addr    string
+		tls     bool
+		str     string
+	}{
+		{"localhost:8443", "tcp4", "localhost:8443", true, "https://localhost:8443"},
+		{"https://0.0.0.0:443", "tcp4", "0.0.0.0:443", true, "https://0.0.0.0:443"},
+		{"http://127.0.0.1:8080", "tcp4", "127.0.0.1:8080", false, "http://127.0.0.1:8080"},
+		{":8080", "tcp4", ":8080", true, "https://:8080"},
+		{"[::]:443", "tcp6", "[::]:443", true, "https://[::]:443"},
+		{"http://[::1]:8080", "tcp6", "[::1]:8080", false, "http://[::1]:8080"},
+		{"https://[::ffff:127.0.0.1]:443", "tcp4", "[::ffff:127.0.0.1]:443", true, "https://[::ffff:127.0.0.1]:443"},
+		{"unix:/run/ttt.sock", "unix", "/run/ttt.sock", false, "unix:/run/ttt.sock"},
+		{"unix:ttt.sock", "unix", "ttt.sock", false, "unix:ttt.sock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			a, err := parseListenAddr(tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.network != tt.network || a.addr != tt.addr || a.tls != tt.tls {
+				t.Errorf("got %s %s tls %v, expected %s %s tls %v", a.network, a.addr, a.tls, tt.network, tt.addr, tt.tls)
+			}
+			if a.String() != tt.str {
+				t.Errorf("got %s, expected %s", a, tt.str)
+			}
+		})
+	}
+
+	for _, s := range []string{"unix:", "localhost", "http://localhost", "https://", "::1:443", "ftp://localhost:21"} {
+		if _, err := parseListenAddr(s); err == nil {
+			t.Errorf("%s is parsed", s)
+		}
+	}
+}
+
+func TestParseListenAddrs(t *testing.T) {
+	addrs, err := parseListenAddrs(" https://:8443, http://127.0.0.1:8080,,unix:/run/ttt.sock ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 3 || addrs[0].String() != "https://:8443" || addrs[1].String() != "http://127.0.0.1:8080" || addrs[2].String() != "unix:/run/ttt.sock" {
+		t.Fatalf("unexpected addresses %v", addrs)
+	}
+	if _, err = parseListenAddrs(" , "); err == nil {
+		t.Error("empty list is parsed")
+	}
+}
+
+func TestListenURL(t *testing.T) {
+	tests := []struct {
+		addrs string
+		url   string
+	}{
+		{"localhost:8443", "https://localhost:8443"},
+		{"https://0.0.0.0:443", "https://games-1"},
+		{":8443", "https://games-1:8443"},
+		{"http://[::]:80", "http://games-1"},
+		{"http://[::1]:8080", "http://[::1]:8080"},
+		{"https://[::1]:443", "https://[::1]"},
+		{"http://games.example.com:80", "http://games.example.com"},
+		{"unix:/run/ttt.sock,http://127.0.0.1:8080", "http://127.0.0.1:8080"},
+		{"unix:/run/ttt.sock", "http://localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addrs, func(t *testing.T) {
+			addrs, err := parseListenAddrs(tt.addrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u := listenURL(addrs, "games-1"); u != tt.url {
+				t.Errorf("got %s, expected %s", u, tt.url)
+			}
+		})
+	}
+}
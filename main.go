@@ -4,17 +4,92 @@ import (
 	"flag"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"strings"
+	"tic-tac-toe/apispec"
 	"tic-tac-toe/game"
+	"time"
 )
 
 var (
-	addr        = flag.String("addr", "0.0.0.0:443", "TCP address to listen to")
+	addr        = flag.String("addr", "0.0.0.0:443", "comma separated addresses to listen to: host:port or https://host:port for TLS, http://host:port for plain HTTP, unix:/path/to/socket for plain HTTP over unix socket, IPv6 hosts are in brackets")
 	cert        = flag.String("cert", "ssl/cert.pem", "path to tls-cert file")
 	key         = flag.String("key", "ssl/key.pem", "path to tls-key file")
+	certReload  = flag.Duration("certReloadInterval", time.Minute, "how often tls-cert and tls-key files are checked for changes, 0 disables reload")
 	storagePath = flag.String("storagePath", "storage", "path to storage with game files")
 	debug       = flag.Bool("debug", false, "print debug messages")
+	keyFile     = flag.String("storageKeyFile", "", "path to file with base64 encoded storage encryption keys, first key is primary (keys could be set by "+storageKeysEnv+" env var too)")
+	retention   = flag.Duration("trashRetention", 30*24*time.Hour, "deleted games are purged after this period, 0 disables purging")
+	idemWindow  = flag.Duration("idempotencyWindow", 24*time.Hour, "repeated requests with the same Idempotency-Key get the original response within this period, 0 disables it")
+	migrateTo   = flag.String("migrateTo", "", "path to new storage, games are written to both storages and copied to the new one in background")
+	backfill    = flag.Duration("backfillInterval", time.Hour, "how often games are copied to the new storage during migration")
+	proxies     = flag.String("trustedProxies", "", "comma separated addresses and CIDR networks of proxies whose X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers are trusted, unix socket clients are always trusted")
+	publicURL   = flag.String("publicURL", "", "public URL of the service used in generated links, e.g. https://games.example.com (default is scheme and host forwarded by trusted proxy or URL of the first TCP listen address)")
+	adminToken  = flag.String("adminToken", "", "token accepted by admin endpoints as admin role")
+	rolesFile   = flag.String("rolesFile", "", "path to file with `subject role` lines, roles are player, moderator and admin")
+	auditLog    = flag.String("auditLog", "", "path to hash-chained audit log of game changes and admin actions, audit is disabled if empty")
+	bansFile    = flag.String("bansFile", "", "path to file to keep banned clients in, bans are lost on restart if empty")
+	apiKeysFile = flag.String("apiKeysFile", "", "path to file with `name:sha256` lines of API keys, clients must send X-API-Key header if set")
+	clientCA    = flag.String("clientCA", "", "path to CA bundle to verify client certificates, clients could authenticate by certificates if set")
+	accounts    = flag.Bool("accounts", false, "enable user registration and password login, anonymous clients are rejected then")
+	sessionTTL  = flag.Duration("sessionTTL", 7*24*time.Hour, "lifetime of user login sessions")
+	createRate  = flag.Int("createRate", 30, "games each client could start per minute, 0 is unlimited")
+	moveRate    = flag.Int("moveRate", 120, "moves each client could make per minute, 0 is unlimited")
+	listRate    = flag.Int("listRate", 60, "list requests each client could make per minute, 0 is unlimited")
+	loginRate   = flag.Int("loginRate", 10, "registration and login attempts per minute from one IP address, 0 is unlimited")
+	maxRunning  = flag.Int("maxRunningGames", 100, "maximum number of running games of client, 0 is unlimited")
+	corsOrigins = flag.String("corsOrigins", "", "comma separated origins of browser clients allowed to call API, * allows any origin, CORS is disabled if empty")
+	corsMethods = flag.String("corsMethods", "GET,POST,PUT,DELETE", "comma separated methods allowed for cross-origin requests")
+	corsHeaders = flag.String("corsHeaders", "Accept,Authorization,Content-Type,Idempotency-Key,If-None-Match,X-API-Key", "comma separated request headers allowed for cross-origin requests")
+	corsCreds   = flag.Bool("corsCredentials", false, "allow cross-origin requests with cookies and client certificates")
+	corsMaxAge  = flag.Duration("corsMaxAge", 10*time.Minute, "how long browsers could cache preflight responses")
+	jwtKeyFiles = flag.String("jwtKeyFiles", "", "comma separated paths to PEM public keys or hmac: prefixed HMAC secrets to verify JWT bearer tokens, kid=<id>= prefix sets key id")
+	jwksFile    = flag.String("jwksFile", "", "path to JWKS file with keys to verify JWT bearer tokens")
+	jwtIssuer   = flag.String("jwtIssuer", "", "expected issuer of JWT bearer tokens, not checked if empty")
+	jwtAudience = flag.String("jwtAudience", "", "expected audience of JWT bearer tokens, not checked if empty")
 )
 
+const storageKeysEnv = "TTT_STORAGE_KEYS"
+
+// open game storage with encryption keys, if they are set
+func openStorage(logger *log.Logger) (*game.StorageFile, error) {
+	return openStorageAt(*storagePath, logger)
+}
+
+func openStorageAt(path string, logger *log.Logger) (*game.StorageFile, error) {
+	storage, err := game.NewStorage(path, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := game.LoadKeyring(*keyFile, os.Getenv(storageKeysEnv))
+	if err != nil {
+		return nil, err
+	}
+	if keyring != nil {
+		logger.Infoln("storage encryption is enabled for", path)
+		storage.SetKeyring(keyring)
+	}
+	return storage, nil
+}
+
+func loadJWTVerifier() (*jwtVerifier, error) {
+	verifier := &jwtVerifier{issuer: *jwtIssuer, audience: *jwtAudience}
+	for _, fname := range strings.Split(*jwtKeyFiles, ",") {
+		if fname = strings.TrimSpace(fname); fname == "" {
+			continue
+		}
+		if err := verifier.loadKeyFile(fname); err != nil {
+			return nil, err
+		}
+	}
+	if *jwksFile != "" {
+		if err := verifier.loadJWKS(*jwksFile); err != nil {
+			return nil, err
+		}
+	}
+	return verifier, nil
+}
+
 func initLogger() *log.Logger {
 	flag.Parse()
 	logger := log.New()
@@ -37,12 +112,133 @@ func initLogger() *log.Logger {
 func main() {
 	// init logger
 	logger := initLogger()
-	storage, err := game.NewStorage(*storagePath, logger)
+
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args(), logger); err != nil {
+			logger.Fatalf("%s: %s", flag.Arg(0), err)
+		}
+		return
+	}
+
+	storage, err := openStorage(logger)
 	if err != nil {
 		logger.Fatal("can't open game files storage: ", err)
 	}
+	// offline commands refuse to change storage of running service
+	if err = storage.Lock(); err != nil {
+		logger.Fatal("can't lock game files storage: ", err)
+	}
+
+	var gameStorage game.Storage = storage
+	if *migrateTo != "" {
+		to, err := openStorageAt(*migrateTo, logger)
+		if err != nil {
+			logger.Fatal("can't open new game files storage: ", err)
+		}
+		if err = to.Lock(); err != nil {
+			logger.Fatal("can't lock new game files storage: ", err)
+		}
+		migration := game.NewMigrationStorage(storage, to, logger)
+		migration.StartBackfill(*backfill)
+		gameStorage = migration
+		logger.Infoln("migrating games to", *migrateTo)
+	}
+
+	ws := NewServer(*addr, *cert, *key, gameStorage, logger)
+	ws.adminToken = *adminToken
+	ws.debug = *debug
+	ws.recentErrors = newRecentErrors()
+	logger.AddHook(ws.recentErrors)
+	ws.trashRetention = *retention
+	ws.idempotencyWindow = *idemWindow
+	if *publicURL != "" {
+		ws.publicURL = strings.TrimRight(*publicURL, "/")
+	} else {
+		logger.Warnln("links are generated for", ws.listenURL, "unless trusted proxy sets X-Forwarded-Host, set -publicURL to the URL which clients use")
+	}
+	ws.certReload = *certReload
+	ws.proxies, err = parseTrustedProxies(*proxies)
+	if err != nil {
+		logger.Fatal("invalid trusted proxies: ", err)
+	}
+	ws.maxRunningGames = *maxRunning
+	for class, perMinute := range map[string]int{rateCreate: *createRate, rateMove: *moveRate, rateList: *listRate, rateLogin: *loginRate} {
+		if perMinute > 0 {
+			ws.rateLimits[class] = newRateLimiter(perMinute)
+		}
+	}
+
+	ws.cors, err = newCORSConfig(*corsOrigins, *corsMethods, *corsHeaders, *corsCreds, int(corsMaxAge.Seconds()))
+	if err != nil {
+		logger.Fatal("invalid CORS config: ", err)
+	}
+	if ws.cors != nil {
+		logger.Infoln("cross-origin requests are allowed from", *corsOrigins)
+	}
+	if *auditLog != "" {
+		ws.auditLog, err = game.OpenAuditLog(*auditLog, logger)
+		if err != nil {
+			logger.Fatal("can't open audit log: ", err)
+		}
+		logger.Infoln("audit log is written to", *auditLog)
+	}
+	if *rolesFile != "" {
+		ws.roles, err = loadRoles(*rolesFile)
+		if err != nil {
+			logger.Fatal("can't load roles: ", err)
+		}
+		logger.Infof("roles are assigned to %d clients", len(ws.roles))
+	}
+	if *bansFile != "" {
+		ws.bans, err = loadBanList(*bansFile)
+		if err != nil {
+			logger.Fatal("can't load bans: ", err)
+		}
+	}
+	if ws.adminEnabled() {
+		logger.Infoln("admin endpoints are enabled")
+	}
 
-	ws := NewServer(*addr, *cert, *key, storage, logger)
+	if *apiKeysFile != "" {
+		keys, err := loadAPIKeys(*apiKeysFile)
+		if err != nil {
+			logger.Fatal("can't load API keys: ", err)
+		}
+		ws.authenticators = append(ws.authenticators, keys.authenticate)
+		logger.Infof("API key authentication is enabled, %d keys", len(keys))
+	}
+	if *clientCA != "" {
+		ws.clientCAs, err = loadCertPool(*clientCA)
+		if err != nil {
+			logger.Fatal("can't load client CA bundle: ", err)
+		}
+		ws.authenticators = append(ws.authenticators, ws.clientCertIdentity)
+		logger.Infoln("client certificate authentication is enabled")
+	}
+	if *accounts {
+		// session tokens are bearer tokens too, so they must be checked before JWTs
+		ws.accounts = true
+		ws.sessionTTL = *sessionTTL
+		ws.authenticators = append(ws.authenticators, ws.sessionIdentity)
+		logger.Infoln("user accounts are enabled")
+	}
+	if *jwtKeyFiles != "" || *jwksFile != "" {
+		verifier, err := loadJWTVerifier()
+		if err != nil {
+			logger.Fatal("can't load JWT keys: ", err)
+		}
+		ws.authenticators = append(ws.authenticators, verifier.authenticate)
+		logger.Infof("JWT authentication is enabled, %d keys", len(verifier.keys))
+	}
+
+	ws.spec, err = apispec.Load(apiSpecYAML)
+	if err != nil {
+		logger.Fatal("can't load API spec: ", err)
+	}
+	ws.openAPI, err = ws.spec.OpenAPI3()
+	if err != nil {
+		logger.Fatal("can't convert API spec: ", err)
+	}
 
 	err = ws.Run()
 	if err != nil {
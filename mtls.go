@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"net"
+	"sync"
+)
+
+// TLS config of the server. Service certificate is generated if it is missing and reloaded when its
+// files are changed. Client certificates are requested if client CA bundle is set, they are
+// optional on TLS level, so clients could authenticate by other methods
+func (ws *webServer) tlsConfig(addrs []*listenAddr) (*tls.Config, error) {
+	err := ensureCerts(ws.certFile, ws.keyFile, certHosts(addrs, ws.publicURL), ws.Log)
+	if err != nil {
+		return nil, err
+	}
+	ws.certs, err = newCertReloader(ws.certFile, ws.keyFile, ws.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		GetCertificate:           ws.certs.getCertificate,
+		PreferServerCipherSuites: true,
+	}
+	if ws.clientCAs != nil {
+		cfg.ClientCAs = ws.clientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// load PEM encoded CA certificates
+func loadCertPool(fname string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New(fname + ": no certificates found")
+	}
+	return pool, nil
+}
+
+// listener which keeps TLS connections by remote address. Server wraps connections to limit them
+// per IP, so request context can't get TLS state of its connection itself
+type tlsListener struct {
+	net.Listener
+	conns *sync.Map // remote address -> *tls.Conn
+}
+
+type tlsConn struct {
+	*tls.Conn
+	conns *sync.Map
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*tls.Conn)
+	l.conns.Store(tlsConnKey(conn.LocalAddr(), conn.RemoteAddr()), conn)
+	return &tlsConn{Conn: conn, conns: l.conns}, nil
+}
+
+func (c *tlsConn) Close() error {
+	c.conns.Delete(tlsConnKey(c.LocalAddr(), c.RemoteAddr()))
+	return c.Conn.Close()
+}
+
+// connections are told apart by both addresses, client could connect to several listeners from the same port
+func tlsConnKey(local, remote net.Addr) string {
+	return local.String() + "-" + remote.String()
+}
+
+// TLS state of request connection, nil for plain connections
+func (ws *webServer) tlsState(ctx *fasthttp.RequestCtx) *tls.ConnectionState {
+	c, ok := ws.tlsConns.Load(tlsConnKey(ctx.LocalAddr(), ctx.RemoteAddr()))
+	if !ok {
+		return nil
+	}
+	state := c.(*tls.Conn).ConnectionState()
+	return &state
+}
+
+// authenticate client by certificate verified in TLS handshake. Players are identified by
+// certificate common name
+func (ws *webServer) clientCertIdentity(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	state := ws.tlsState(ctx)
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, errors.New("client certificate has no common name")
+	}
+	return &Identity{Subject: "cert:" + cert.Subject.CommonName, Method: "client-cert"}, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// client cert signed by CA
+func testClientCert(t *testing.T, name string, caCert *x509.Certificate, caKey interface{}) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := certTemplate(pkix.Name{CommonName: name}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSListener_ClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tic-tac-toe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// service cert is signed by the same local CA as client certs
+	caCert, caKey, err := generateCA(filepath.Join(dir, caCertName), filepath.Join(dir, caKeyName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCACert, otherCAKey, err := generateCA(filepath.Join(dir, "other.crt"), filepath.Join(dir, "other.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	ws := &webServer{
+		Log:       testLogger(),
+		certFile:  filepath.Join(dir, "service.crt"),
+		keyFile:   filepath.Join(dir, "service.key"),
+		clientCAs: pool,
+	}
+	cfg, err := ws.tlsConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fasthttp.Server{
+		Handler: ws.Proxy(func(ctx *fasthttp.RequestCtx) {
+			id, err := ws.clientCertIdentity(ctx)
+			switch {
+			case err != nil:
+				ctx.Error(err.Error(), fasthttp.StatusUnauthorized)
+			case id == nil:
+				ctx.SetBodyString("anonymous " + requestScheme(ctx))
+			default:
+				ctx.SetBodyString(id.Subject + " " + id.Method + " " + requestScheme(ctx))
+			}
+		}),
+		Logger: testLogger(),
+	}
+	go func() { _ = server.Serve(&tlsListener{Listener: tls.NewListener(ln, cfg), conns: &ws.tlsConns}) }()
+	defer server.Shutdown()
+
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		body  string
+	}{
+		{"no client cert", nil, "anonymous https"},
+		{"client cert", []tls.Certificate{testClientCert(t, "alice", caCert, caKey)}, "cert:alice client-cert https"},
+		{"cert without common name", []tls.Certificate{testClientCert(t, "", caCert, caKey)}, "client certificate has no common name"},
+		{"cert of unknown CA", []tls.Certificate{testClientCert(t, "mallory", otherCACert, otherCAKey)}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      pool,
+				Certificates: tt.certs,
+				ServerName:   "localhost",
+			}}}
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get("https://" + ln.Addr().String() + "/")
+			if tt.body == "" {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("handshake with cert of unknown CA succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("got %q, expected %q", body, tt.body)
+			}
+		})
+	}
+
+	// closed connections are forgotten
+	for i := 0; ; i++ {
+		n := 0
+		ws.tlsConns.Range(func(_, _ interface{}) bool {
+			n++
+			return true
+		})
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("%d closed connections are kept", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTLSState_PlainConnection(t *testing.T) {
+	ws := &webServer{}
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}, testLogger())
+	if ws.tlsState(&ctx) != nil {
+		t.Fatal("plain connection has TLS state")
+	}
+	if id, err := ws.clientCertIdentity(&ctx); id != nil || err != nil {
+		t.Fatalf("got %v, %v, expected no identity", id, err)
+	}
+}
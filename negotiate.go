@@ -0,0 +1,146 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"strconv"
+	"strings"
+	"tic-tac-toe/codec"
+	"tic-tac-toe/game"
+)
+
+const (
+	applicationMsgpack = "application/msgpack"
+	applicationCBOR    = "application/cbor"
+)
+
+// media types which could be negotiated, json is the default one
+var mediaFormats = map[string]string{
+	applicationJson:           applicationJson,
+	applicationProblemJson:    applicationJson,
+	applicationMsgpack:        applicationMsgpack,
+	"application/x-msgpack":   applicationMsgpack,
+	"application/vnd.msgpack": applicationMsgpack,
+	applicationCBOR:           applicationCBOR,
+	"application/*":           applicationJson,
+	"*/*":                     applicationJson,
+}
+
+// handlers work with json only. Request bodies in MessagePack or CBOR are converted to json before
+// validation, json responses are converted to the format chosen by Accept header
+func (ws *webServer) Negotiate(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	fn := func(ctx *fasthttp.RequestCtx) {
+		format := negotiate(string(ctx.Request.Header.Peek(fasthttp.HeaderAccept)))
+		if format == "" {
+			setProblem(ctx, game.NewGameError(fasthttp.StatusNotAcceptable, "supported media types are "+applicationJson+", "+applicationMsgpack+", "+applicationCBOR))
+			return
+		}
+
+		if err := decodeRequestBody(ctx); err != nil {
+			ws.requestLog(ctx, "Negotiate").Debugln("can't decode request body:", err)
+			setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, "can't decode request body", err).WithCode(game.CodeInvalidRequest))
+			return
+		}
+
+		// do next
+		next(ctx)
+
+		ctx.Response.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderAccept)
+		if format != applicationJson {
+			ws.encodeResponseBody(ctx, format)
+		}
+	}
+	return fn
+}
+
+// choose response format by Accept header, empty if nothing is acceptable. Specific media
+// types win over wildcards with the same quality
+func negotiate(accept string) string {
+	if accept == "" {
+		return applicationJson
+	}
+
+	best, bestQ, bestWildcard := "", 0.0, false
+	for _, item := range strings.Split(accept, ",") {
+		params := strings.Split(item, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		format, ok := mediaFormats[mediaType]
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		wildcard := strings.HasSuffix(mediaType, "*")
+		if q > bestQ || (q == bestQ && bestWildcard && !wildcard) {
+			best, bestQ, bestWildcard = format, q, wildcard
+		}
+	}
+	return best
+}
+
+// convert MessagePack or CBOR request body to json
+func decodeRequestBody(ctx *fasthttp.RequestCtx) error {
+	contentType := string(ctx.Request.Header.ContentType())
+	if idx := strings.IndexByte(contentType, ';'); idx >= 0 {
+		contentType = contentType[:idx]
+	}
+
+	var decode func(a *fastjson.Arena, data []byte) (*fastjson.Value, error)
+	switch mediaFormats[strings.ToLower(strings.TrimSpace(contentType))] {
+	case applicationMsgpack:
+		decode = codec.DecodeMsgpack
+	case applicationCBOR:
+		decode = codec.DecodeCBOR
+	default:
+		return nil
+	}
+
+	a := arenaPool.Get()
+	defer arenaPool.Put(a)
+
+	v, err := decode(a, ctx.Request.Body())
+	if err != nil {
+		return err
+	}
+	ctx.Request.SetBody(v.MarshalTo(nil))
+	ctx.Request.Header.SetContentType(applicationJson)
+	return nil
+}
+
+// convert json response body, streamed responses are read completely
+func (ws *webServer) encodeResponseBody(ctx *fasthttp.RequestCtx, format string) {
+	if !strings.Contains(string(ctx.Response.Header.ContentType()), "json") {
+		return
+	}
+	body := ctx.Response.Body()
+	if len(body) == 0 {
+		return
+	}
+
+	p := ws.parserPool.Get()
+	defer ws.parserPool.Put(p)
+
+	v, err := p.ParseBytes(body)
+	if err != nil {
+		ws.requestLog(ctx, "encodeResponseBody").Errorln("can't convert response to", format, err)
+		return
+	}
+
+	var res []byte
+	if format == applicationMsgpack {
+		res = codec.EncodeMsgpack(nil, v)
+	} else {
+		res = codec.EncodeCBOR(nil, v)
+	}
+	ctx.SetContentType(format)
+	ctx.SetBody(res)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp"
+	"net"
+	"strings"
+)
+
+const (
+	clientIPKey = "client_ip" // request ctx key of client address
+	schemeKey   = "scheme"    // request ctx key of scheme used by client
+	hostKey     = "host"      // request ctx key of host forwarded by trusted proxy
+
+	headerForwardedFor   = "X-Forwarded-For"
+	headerForwardedProto = "X-Forwarded-Proto"
+	headerForwardedHost  = "X-Forwarded-Host"
+)
+
+// networks of proxies whose X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers are trusted
+type trustedProxies []*net.IPNet
+
+// parse comma separated IP addresses and CIDR networks, e.g. `10.0.0.0/8,192.168.1.10`
+func parseTrustedProxies(s string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, errors.New("invalid proxy address " + v)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) contains(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// client address from X-Forwarded-For set by trusted proxy. Addresses are added by every proxy, so
+// the last untrusted one is the client, the former ones could be forged by client
+func (p trustedProxies) forwardedFor(peer net.IP, header string) net.IP {
+	client := peer
+	hops := strings.Split(header, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !p.contains(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// host requested by client from X-Forwarded-Host set by trusted proxy, the first proxy knows it.
+// Empty if header is missing or host isn't `name[:port]`
+func forwardedHost(header string) string {
+	host := strings.ToLower(strings.TrimSpace(strings.Split(header, ",")[0]))
+	if len(host) > 255 {
+		return ""
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune(".-:[]", c)) {
+			return ""
+		}
+	}
+	return host
+}
+
+// resolve client address, scheme and host. Connections of trusted proxies and unix socket ones, which
+// are made by local proxy, are taken from X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers
+func (ws *webServer) Proxy(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	fn := func(ctx *fasthttp.RequestCtx) {
+		ip := ctx.RemoteIP()
+		scheme := "http"
+		if ws.tlsState(ctx) != nil {
+			scheme = "https"
+		}
+
+		_, unix := ctx.RemoteAddr().(*net.UnixAddr)
+		if unix || ws.proxies.contains(ip) {
+			if header := ctx.Request.Header.Peek(headerForwardedFor); len(header) > 0 {
+				ip = ws.proxies.forwardedFor(ip, string(header))
+			}
+			// the first proxy knows scheme of client
+			proto := strings.ToLower(strings.TrimSpace(strings.Split(string(ctx.Request.Header.Peek(headerForwardedProto)), ",")[0]))
+			if proto == "http" || proto == "https" {
+				scheme = proto
+			}
+			if host := forwardedHost(string(ctx.Request.Header.Peek(headerForwardedHost))); host != "" {
+				ctx.SetUserValue(hostKey, host)
+			}
+		}
+		ctx.SetUserValue(clientIPKey, ip.String())
+		ctx.SetUserValue(schemeKey, scheme)
+
+		// do next
+		next(ctx)
+	}
+	return fn
+}
+
+// IP address of client, it is resolved by Proxy middleware
+func clientIP(ctx *fasthttp.RequestCtx) string {
+	if ip, ok := ctx.UserValue(clientIPKey).(string); ok {
+		return ip
+	}
+	return ctx.RemoteIP().String()
+}
+
+// scheme used by client to make request, it is resolved by Proxy middleware
+func requestScheme(ctx *fasthttp.RequestCtx) string {
+	if scheme, ok := ctx.UserValue(schemeKey).(string); ok {
+		return scheme
+	}
+	return "https"
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"net"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies(" 10.0.0.0/8, 192.168.1.10 ,fd00::/8,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, trusted := range map[string]bool{
+		"10.1.2.3":     true,
+		"192.168.1.10": true,
+		"192.168.1.11": false,
+		"fd00::1":      true,
+		"::1":          true,
+		"2001:db8::1":  false,
+	} {
+		if proxies.contains(net.ParseIP(ip)) != trusted {
+			t.Errorf("%s: expected trusted %v", ip, trusted)
+		}
+	}
+
+	for _, s := range []string{"10.0.0.300", "10.0.0.0/33", "proxy.local"} {
+		if _, err = parseTrustedProxies(s); err == nil {
+			t.Errorf("%s is parsed", s)
+		}
+	}
+}
+
+func TestTrustedProxies_ForwardedFor(t *testing.T) {
+	proxies, _ := parseTrustedProxies("10.0.0.0/8,2001:db8::/32")
+	peer := net.ParseIP("10.0.0.1")
+
+	tests := []struct {
+		name   string
+		header string
+		client string
+	}{
+		{"client", "203.0.113.5", "203.0.113.5"},
+		{"chain of proxies", "203.0.113.5, 10.0.0.3, 10.0.0.2", "203.0.113.5"},
+		{"spoofed left-most hop", "1.1.1.1, 203.0.113.5", "203.0.113.5"},
+		{"spoofed hops before proxies", "1.1.1.1, 2.2.2.2, 203.0.113.5, 10.0.0.2", "203.0.113.5"},
+		{"spoofed trusted hop", "10.0.0.7, 203.0.113.5", "203.0.113.5"},
+		{"unparseable hop", "203.0.113.5, unknown, 10.0.0.2", "10.0.0.2"},
+		{"unparseable last hop", "203.0.113.5, unknown", "10.0.0.1"},
+		{"hop with port", "203.0.113.5:1234", "10.0.0.1"},
+		{"empty hop", "203.0.113.5,", "10.0.0.1"},
+		{"IPv6 client", "2001:db9::1, 2001:db8::2", "2001:db9::1"},
+		{"trusted proxies only", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if client := proxies.forwardedFor(peer, tt.header); client.String() != tt.client {
+				t.Errorf("got %s, expected %s", client, tt.client)
+			}
+		})
+	}
+}
+
+func TestForwardedHost(t *testing.T) {
+	for header, host := range map[string]string{
+		"games.example.com":                  "games.example.com",
+		" Games.Example.com:8443, proxy.lan": "games.example.com:8443",
+		"[2001:db8::1]:443":                  "[2001:db8::1]:443",
+		"":                                   "",
+		"evil.com/path":                      "",
+		"evil.com@games.example.com":         "",
+		"games example com":                  "",
+	} {
+		if v := forwardedHost(header); v != host {
+			t.Errorf("%q: got %q, expected %q", header, v, host)
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	proxies, _ := parseTrustedProxies("10.0.0.0/8")
+	ws := &webServer{proxies: proxies}
+	var ip, scheme string
+	h := ws.Proxy(func(ctx *fasthttp.RequestCtx) {
+		ip, scheme = clientIP(ctx), requestScheme(ctx)
+	})
+
+	proxy := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 50000}
+	socket := &net.UnixAddr{Name: "@", Net: "unix"}
+
+	tests := []struct {
+		name    string
+		remote  net.Addr
+		headers []string
+		ip      string
+		scheme  string
+	}{
+		{"direct client", client, nil, "203.0.113.5", "http"},
+		{"forged headers of client", client, []string{headerForwardedFor, "1.1.1.1", headerForwardedProto, "https"}, "203.0.113.5", "http"},
+		{"trusted proxy", proxy, []string{headerForwardedFor, "203.0.113.7", headerForwardedProto, "https"}, "203.0.113.7", "https"},
+		{"trusted proxy without headers", proxy, nil, "10.0.0.1", "http"},
+		{"scheme of the first proxy", proxy, []string{headerForwardedProto, "HTTPS, http"}, "10.0.0.1", "https"},
+		{"unknown scheme", proxy, []string{headerForwardedProto, "ws"}, "10.0.0.1", "http"},
+		{"unix socket", socket, []string{headerForwardedFor, "203.0.113.7", headerForwardedProto, "https"}, "203.0.113.7", "https"},
+		{"unix socket spoofed hop", socket, []string{headerForwardedFor, "1.1.1.1, 203.0.113.7"}, "203.0.113.7", "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req fasthttp.Request
+			req.SetRequestURI("/api/v1/games")
+			for i := 0; i+1 < len(tt.headers); i += 2 {
+				req.Header.Set(tt.headers[i], tt.headers[i+1])
+			}
+			var ctx fasthttp.RequestCtx
+			ctx.Init(&req, tt.remote, testLogger())
+			ip, scheme = "", ""
+			h(&ctx)
+			if ip != tt.ip || scheme != tt.scheme {
+				t.Errorf("got %s %s, expected %s %s", ip, scheme, tt.ip, tt.scheme)
+			}
+		})
+	}
+}
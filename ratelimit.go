@@ -0,0 +1,181 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"math"
+	"strconv"
+	"sync"
+	"tic-tac-toe/game"
+	"time"
+)
+
+// request classes with separate rate limits
+const (
+	rateCreate = "create"
+	rateMove   = "move"
+	rateList   = "list"
+	rateLogin  = "login"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// token bucket rate limiter. Bucket holds up to `burst` tokens and is refilled with `rate` tokens
+// per second, every request takes one token
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	cleaned time.Time
+}
+
+// limiter allowing `perMinute` requests per minute, all of them could be made at once
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// take token from bucket of client. If bucket is empty, time until the next token is returned
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cleanup(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// forget clients whose buckets are full again, they are the same as new ones. Caller must hold the lock
+func (l *rateLimiter) cleanup(now time.Time) {
+	if now.Sub(l.cleaned) < time.Minute {
+		return
+	}
+	l.cleaned = now
+
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// authenticated clients are limited by identity, anonymous ones by IP address
+func rateLimitKey(ctx *fasthttp.RequestCtx) string {
+	if id := identity(ctx); id != nil {
+		return id.Subject
+	}
+	return "ip:" + clientIP(ctx)
+}
+
+// limit rate of requests of the class, `429 Too Many Requests` is returned with `Retry-After` if
+// client exceeds the limit. Requests are not limited if there is no limit for the class
+func (ws *webServer) RateLimit(class string, next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	limiter := ws.rateLimits[class]
+	if limiter == nil {
+		return next
+	}
+
+	fn := func(ctx *fasthttp.RequestCtx) {
+		key := rateLimitKey(ctx)
+		if ok, wait := limiter.allow(key, time.Now()); !ok {
+			logger := ws.requestLog(ctx, "RateLimit")
+			logger.Infof("%s rate limit exceeded by %s", class, key)
+
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			setProblem(ctx, game.NewGameError(fasthttp.StatusTooManyRequests, class+" rate limit exceeded").WithCode(game.CodeRateLimited))
+			return
+		}
+
+		// do next
+		next(ctx)
+	}
+	return fn
+}
+
+// client whose running games are counted: owner of the game or IP address of anonymous creator.
+// Games started anonymously before creators were recorded are not counted
+func gameClient(g *game.Game) string {
+	switch {
+	case g.Owner() != "":
+		return g.Owner()
+	case g.Creator() != "":
+		return "ip:" + g.Creator()
+	}
+	return ""
+}
+
+// numbers of running games by client. They are counted from storage on start and then kept up to
+// date by handlers, so starting a game doesn't list all games
+type runningGames struct {
+	mu     sync.Mutex
+	counts map[string]int // nil if games are not limited
+}
+
+func (r *runningGames) load(storage game.Storage) error {
+	games, err := storage.List()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts = make(map[string]int)
+	for _, g := range games {
+		if client := gameClient(g); client != "" && g.Status() == game.RUNNING {
+			r.counts[client]++
+		}
+	}
+	return nil
+}
+
+// take a slot for a new game of client. Check and increment are atomic, so concurrent requests
+// can't exceed the limit. Slot must be released if the game isn't saved
+func (r *runningGames) reserve(client string, max int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counts == nil {
+		return nil
+	}
+	if r.counts[client] >= max {
+		return game.NewGameError(fasthttp.StatusTooManyRequests, "too many running games, finish or delete some of them").WithCode(game.CodeTooManyGames)
+	}
+	r.counts[client]++
+	return nil
+}
+
+// change number of running games of client, e.g. -1 when game is finished or deleted
+func (r *runningGames) add(client string, delta int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counts == nil || client == "" {
+		return
+	}
+	if r.counts[client] += delta; r.counts[client] <= 0 {
+		delete(r.counts, client)
+	}
+}
+
+// update counter when running game is finished, deleted or restored
+func (ws *webServer) countRunning(g *game.Game, delta int) {
+	if g != nil && g.Status() == game.RUNNING {
+		ws.running.add(gameClient(g), delta)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"testing"
+	"tic-tac-toe/game"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	l := newRateLimiter(60) // one token per second
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 60; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d is limited within burst", i)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait != time.Second {
+		t.Fatalf("empty bucket: got %v, %s, expected false, 1s", ok, wait)
+	}
+	if ok, _ = l.allow("b", now); !ok {
+		t.Fatal("other client is limited")
+	}
+
+	ok, wait = l.allow("a", now.Add(250*time.Millisecond))
+	if ok || wait != 750*time.Millisecond {
+		t.Fatalf("partly refilled bucket: got %v, %s, expected false, 750ms", ok, wait)
+	}
+	if ok, _ = l.allow("a", now.Add(time.Second)); !ok {
+		t.Fatal("request is limited after refill")
+	}
+	if ok, _ = l.allow("a", now.Add(time.Second)); ok {
+		t.Fatal("the only refilled token is taken twice")
+	}
+}
+
+func TestRateLimiter_Cleanup(t *testing.T) {
+	l := newRateLimiter(60)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l.allow("idle", now)
+	for i := 0; i < 60; i++ {
+		l.allow("busy", now.Add(30*time.Second))
+	}
+
+	// idle bucket is full again, busy one is refilled by 30 tokens only
+	l.allow("new", now.Add(61*time.Second))
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("full bucket is not removed")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket which is not full is removed")
+	}
+
+	// cleanup runs once a minute
+	l.allow("idle", now.Add(62*time.Second))
+	l.buckets["idle"].tokens = l.burst
+	l.allow("new", now.Add(63*time.Second))
+	if _, ok := l.buckets["idle"]; !ok {
+		t.Error("cleanup runs more often than once a minute")
+	}
+}
+
+func TestRateLimit_RetryAfter(t *testing.T) {
+	ws := &webServer{Log: testLogger(), rateLimits: map[string]*rateLimiter{rateCreate: newRateLimiter(1)}}
+	h := ws.RateLimit(rateCreate, func(ctx *fasthttp.RequestCtx) {})
+
+	var ctx fasthttp.RequestCtx
+	h(&ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("the first request: got status %d", ctx.Response.StatusCode())
+	}
+	ctx.Response.Reset()
+	h(&ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusTooManyRequests {
+		t.Fatalf("the second request: got status %d, expected 429", ctx.Response.StatusCode())
+	}
+	// one token per minute
+	if v := string(ctx.Response.Header.Peek(fasthttp.HeaderRetryAfter)); v != "60" {
+		t.Errorf("got Retry-After %q, expected 60", v)
+	}
+}
+
+func TestRunningGames(t *testing.T) {
+	var r runningGames
+	if err := r.reserve("key:alice", 1); err != nil {
+		t.Fatalf("unlimited counter: %s", err)
+	}
+
+	r.counts = make(map[string]int)
+	if err := r.reserve("key:alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reserve("key:alice", 1); game.AsGameError(err).Status != fasthttp.StatusTooManyRequests {
+		t.Fatalf("limit is exceeded: %v", err)
+	}
+	if err := r.reserve("ip:127.0.0.1", 1); err != nil {
+		t.Fatalf("other client is limited: %s", err)
+	}
+
+	r.add("key:alice", -1)
+	if err := r.reserve("key:alice", 1); err != nil {
+		t.Fatalf("released slot is not reused: %s", err)
+	}
+}
+
+func TestGameClient(t *testing.T) {
+	g := game.NewGame([]byte("---------"), game.XChar)
+	if c := gameClient(g); c != "" {
+		t.Errorf("legacy anonymous game: got %q", c)
+	}
+	g.SetCreator("10.0.0.1")
+	if c := gameClient(g); c != "ip:10.0.0.1" {
+		t.Errorf("anonymous game: got %q", c)
+	}
+	g.SetOwner("key:alice")
+	if c := gameClient(g); c != "key:alice" {
+		t.Errorf("owned game: got %q", c)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"os"
+	"strconv"
+	"strings"
+	"tic-tac-toe/game"
+)
+
+const (
+	rolePlayer    = "player"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+
+	adminPathPrefix  = "/api/admin/"
+	adminTokenHeader = "X-Admin-Token"
+)
+
+// roles are ordered, every role is allowed to do what lower ones could
+var roleRanks = map[string]int{
+	rolePlayer:    1,
+	roleModerator: 2,
+	roleAdmin:     3,
+}
+
+// roles are assigned to clients by `subject role` lines, clients without role are players
+func loadRoles(fname string) (map[string]string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	roles := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, errors.New(fname + ": line " + strconv.Itoa(n) + ": expected subject and role")
+		}
+		if _, ok := roleRanks[fields[1]]; !ok {
+			return nil, errors.New(fname + ": line " + strconv.Itoa(n) + ": unknown role " + fields[1])
+		}
+		roles[fields[0]] = fields[1]
+	}
+	return roles, scanner.Err()
+}
+
+func (ws *webServer) roleOf(subject string) string {
+	if role, ok := ws.roles[subject]; ok {
+		return role
+	}
+	return rolePlayer
+}
+
+// admin endpoints are enabled if there is admin token or anybody has role
+func (ws *webServer) adminEnabled() bool {
+	return ws.adminToken != "" || len(ws.roles) > 0
+}
+
+// admin token is an admin without any other credentials, it is accepted by endpoints which
+// require a role only
+func (ws *webServer) adminTokenIdentity(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	token := ctx.Request.Header.Peek(adminTokenHeader)
+	if len(token) == 0 {
+		return nil, nil
+	}
+	if ws.adminToken == "" || subtle.ConstantTimeCompare(token, []byte(ws.adminToken)) != 1 {
+		ws.requestLog(ctx, "adminTokenIdentity").Warnln("admin: invalid token from", clientIP(ctx))
+		return nil, errors.New("invalid admin token")
+	}
+	return &Identity{Subject: "admin-token", Method: "admin-token", Role: roleAdmin}, nil
+}
+
+// reject banned IP addresses and guard admin namespace: its clients must be authenticated and be
+// moderators at least. Endpoints which need higher role are wrapped by RequireRole
+func (ws *webServer) Authorize(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	fn := func(ctx *fasthttp.RequestCtx) {
+		admin := strings.HasPrefix(string(ctx.Path()), adminPathPrefix) && ws.adminEnabled()
+
+		// admins could lift ban from their own address
+		if ip := clientIP(ctx); !admin && ws.bans.banned("ip:"+ip) {
+			ws.requestLog(ctx, "Authorize").Infoln("request from banned address", ip)
+			setProblem(ctx, game.NewGameError(fasthttp.StatusForbidden, "client is banned"))
+			return
+		}
+
+		if admin {
+			if err := ws.identify(ctx, ws.roleAuthenticators()); err != nil {
+				setProblem(ctx, err)
+				return
+			}
+			if err := requireRole(ctx, roleModerator); err != nil {
+				ws.requestLog(ctx, "Authorize").Warnln("admin: access denied for", identity(ctx).Subject)
+				setProblem(ctx, err)
+				return
+			}
+		}
+
+		// do next
+		next(ctx)
+	}
+	return fn
+}
+
+// admin token is checked before credentials of players
+func (ws *webServer) roleAuthenticators() []authenticator {
+	return append([]authenticator{ws.adminTokenIdentity}, ws.authenticators...)
+}
+
+// allow request only if client has the role or higher one. Client is identified here unless it is
+// done by Authorize, so endpoints outside of admin namespace could require a role too
+func (ws *webServer) RequireRole(role string, next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	fn := func(ctx *fasthttp.RequestCtx) {
+		if identity(ctx) == nil {
+			if err := ws.identify(ctx, ws.roleAuthenticators()); err != nil {
+				setProblem(ctx, err)
+				return
+			}
+		}
+		if err := requireRole(ctx, role); err != nil {
+			setProblem(ctx, err)
+			return
+		}
+
+		// do next
+		next(ctx)
+	}
+	return fn
+}
+
+func requireRole(ctx *fasthttp.RequestCtx, role string) error {
+	id := identity(ctx)
+	if id == nil || roleRanks[id.Role] < roleRanks[role] {
+		return game.NewGameError(fasthttp.StatusForbidden, role+" role is required")
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"strings"
+	"testing"
+	"tic-tac-toe/game"
+)
+
+func TestDeleteGame_RequiresAdmin(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.authenticators = []authenticator{testAPIKeys().authenticate}
+		ws.roles = map[string]string{"key:alice": roleAdmin}
+	})
+
+	g := game.NewGame([]byte("X---O----"), game.XChar)
+	g.SetOwner("key:bob")
+	if err := ws.storage.Save(g); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		uri     string
+		body    string
+		headers []string
+		status  int
+	}{
+		{"anonymous", "DELETE", "/api/v1/games/" + g.Id(), "", nil, fasthttp.StatusUnauthorized},
+		{"owner is a player", "DELETE", "/api/v1/games/" + g.Id(), "", []string{apiKeyHeader, testBobKey}, fasthttp.StatusForbidden},
+		{"v2 player", "DELETE", "/api/v2/games/" + g.Id(), "", []string{apiKeyHeader, testBobKey}, fasthttp.StatusForbidden},
+		{"batch player", "POST", "/api/v1/games:batchDelete", `{"ids":["` + g.Id() + `"]}`, []string{apiKeyHeader, testBobKey}, fasthttp.StatusForbidden},
+		{"batch action player", "POST", "/api/v1/games:batch", `{"action":"delete","status":"RUNNING"}`, []string{apiKeyHeader, testBobKey}, fasthttp.StatusForbidden},
+		{"admin", "DELETE", "/api/v1/games/" + g.Id(), "", []string{apiKeyHeader, testAliceKey}, fasthttp.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(ws, tt.method, tt.uri, tt.body, tt.headers...)
+			if resp.StatusCode() != tt.status {
+				t.Fatalf("got status %d, expected %d: %s", resp.StatusCode(), tt.status, resp.Body())
+			}
+		})
+	}
+
+	if _, err := ws.storage.GetDeleted(g.Id()); err != nil {
+		t.Fatalf("game isn't deleted by admin: %s", err)
+	}
+}
+
+func TestDeleteGame_AdminToken(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.adminToken = "secret"
+	})
+	g := game.NewGame([]byte("X---O----"), game.XChar)
+	if err := ws.storage.Save(g); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := doRequest(ws, "DELETE", "/api/v1/games/"+g.Id(), ""); resp.StatusCode() != fasthttp.StatusUnauthorized {
+		t.Fatalf("anonymous client: got status %d, expected 401", resp.StatusCode())
+	}
+	resp := doRequest(ws, "DELETE", "/api/v1/games/"+g.Id(), "", adminTokenHeader, "secret")
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("admin token: got status %d: %s", resp.StatusCode(), resp.Body())
+	}
+}
+
+func TestGetAllGames_Anonymous(t *testing.T) {
+	ws := newTestServer(t, nil)
+
+	resp := doRequest(ws, "POST", "/api/v1/games", `{"board":"---------"}`)
+	if resp.StatusCode() != fasthttp.StatusCreated {
+		t.Fatalf("can't start game: %d %s", resp.StatusCode(), resp.Body())
+	}
+	other := game.NewGame([]byte("X---O----"), game.XChar)
+	other.SetCreator("10.0.0.1")
+	if err := ws.storage.Save(other); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = doRequest(ws, "GET", "/api/v1/games", "")
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode())
+	}
+	if body := string(resp.Body()); strings.Count(body, `"id"`) != 1 || strings.Contains(body, other.Id()) {
+		t.Fatalf("list contains games of other clients: %s", body)
+	}
+}
+
+func TestAssignOwner(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.authenticators = []authenticator{testAPIKeys().authenticate}
+		ws.roles = map[string]string{"key:bob": roleAdmin}
+		ws.maxRunningGames = 1
+		ws.running.counts = make(map[string]int)
+	})
+
+	// game created before authentication was enabled
+	g := game.NewGame([]byte("X---O----"), game.XChar)
+	if err := ws.storage.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	uri := "/api/v1/games/" + g.Id()
+	if resp := doRequest(ws, "GET", uri, "", apiKeyHeader, testAliceKey); resp.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("game of nobody: got status %d, expected 403", resp.StatusCode())
+	}
+
+	body := `{"owner":"key:alice"}`
+	if resp := doRequest(ws, "POST", "/api/admin/games/"+g.Id()+"/owner", body, apiKeyHeader, testAliceKey); resp.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("player: got status %d, expected 403", resp.StatusCode())
+	}
+	if resp := doRequest(ws, "POST", "/api/admin/games/"+g.Id()+"/owner", `{}`, apiKeyHeader, testBobKey); resp.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("no owner: got status %d, expected 400", resp.StatusCode())
+	}
+	resp := doRequest(ws, "POST", "/api/admin/games/"+g.Id()+"/owner", body, apiKeyHeader, testBobKey)
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("admin: got status %d: %s", resp.StatusCode(), resp.Body())
+	}
+
+	if resp = doRequest(ws, "GET", uri, "", apiKeyHeader, testAliceKey); resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("assigned game: got status %d, expected 200", resp.StatusCode())
+	}
+	// running game is counted for the new owner
+	if err := ws.running.reserve("key:alice", ws.maxRunningGames); err == nil {
+		t.Fatal("assigned running game isn't counted")
+	}
+}
+
+func TestAdminTrash(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.authenticators = []authenticator{testAPIKeys().authenticate}
+		ws.adminToken = "secret"
+	})
+
+	g := game.NewGame([]byte("X---O----"), game.XChar)
+	g.SetOwner("key:bob")
+	if err := ws.storage.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	if resp := doRequest(ws, "DELETE", "/api/v1/games/"+g.Id(), "", adminTokenHeader, "secret"); resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("can't delete game: %d %s", resp.StatusCode(), resp.Body())
+	}
+
+	// owner still sees the game in own trash
+	if resp := doRequest(ws, "GET", "/api/v1/trash", "", apiKeyHeader, testBobKey); !strings.Contains(string(resp.Body()), g.Id()) {
+		t.Fatalf("owner doesn't see deleted game: %d %s", resp.StatusCode(), resp.Body())
+	}
+
+	if resp := doRequest(ws, "GET", "/api/admin/trash", "", apiKeyHeader, testAliceKey); resp.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("player: got status %d, expected 403", resp.StatusCode())
+	}
+	resp := doRequest(ws, "GET", "/api/admin/trash", "", adminTokenHeader, "secret")
+	if body := string(resp.Body()); resp.StatusCode() != fasthttp.StatusOK || !strings.Contains(body, g.Id()) || !strings.Contains(body, `"owner":"key:bob"`) {
+		t.Fatalf("admin trash: got %d %s", resp.StatusCode(), body)
+	}
+
+	uri := "/api/admin/trash/" + g.Id() + "/restore"
+	if resp = doRequest(ws, "POST", uri, "", apiKeyHeader, testAliceKey); resp.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("player restore: got status %d, expected 403", resp.StatusCode())
+	}
+	if resp = doRequest(ws, "POST", uri, "", adminTokenHeader, "secret"); resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("admin restore: got status %d: %s", resp.StatusCode(), resp.Body())
+	}
+	if restored, err := ws.storage.Get(g.Id()); err != nil || restored.Owner() != "key:bob" {
+		t.Fatalf("game isn't restored for its owner: %v", err)
+	}
+}
@@ -1,33 +1,72 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"github.com/fasthttp/router"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
-	"github.com/valyala/fasthttp/reuseport"
 	"github.com/valyala/fastjson"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
+	"tic-tac-toe/apispec"
 	"tic-tac-toe/game"
+	"time"
 )
 
-const applicationJson = "application/json"
+const (
+	applicationJson        = "application/json"
+	applicationProblemJson = "application/problem+json"
+	problemTypePrefix      = "urn:tic-tac-toe:problem:" // problem type is this prefix followed by error code
+)
 
 type webServer struct {
+	listChanged int64 // unix nano time of last deletion or restore of a game, atomic
+
 	Addr       string
 	Log        *log.Logger
-	ln         net.Listener
+	listeners  []net.Listener
 	router     *router.Router
 	debug      bool
 	wg         sync.WaitGroup
 	certFile   string
 	keyFile    string
+	certs      *certReloader // service certificate, it is reloaded when files are changed
 	storage    game.Storage
 	parserPool *fastjson.ParserPool // reuse parsers to avoid memory allocations
 	server     *fasthttp.Server
+	spec       *apispec.Spec // API spec to validate requests
+	openAPI    []byte        // API spec converted to OpenAPI 3 json
+	certReload time.Duration // how often cert files are checked for changes, 0 disables reload
+	adminToken string        // admin token is accepted by admin endpoints
+	publicURL  string        // base URL of the service for generated links
+	listenURL  string        // URL of the first TCP listen address, links point to it if nothing else is known
+
+	authenticators []authenticator // clients are authenticated if any authenticator is set
+	clientCAs      *x509.CertPool  // client certificates are verified against these CAs if set
+	tlsConns       sync.Map        // TLS connections by local and remote addresses
+	proxies        trustedProxies  // client address and scheme are taken from headers of these proxies
+	accounts       bool            // users could register and log in
+	sessionTTL     time.Duration   // lifetime of login sessions
+
+	roles        map[string]string // roles of clients by subject, clients without role are players
+	bans         *banList          // banned clients
+	recentErrors *recentErrors     // the last logged errors for admins
+	auditLog     *game.AuditLog    // actions of clients are recorded if set
+	cors         *corsConfig       // cross-origin requests are allowed if set
+
+	rateLimits      map[string]*rateLimiter // rate limiters by request class
+	maxRunningGames int                     // maximum number of running games per client, 0 is unlimited
+	running         runningGames            // numbers of running games by client
+
+	trashRetention    time.Duration // deleted games are purged after retention period
+	idempotencyWindow time.Duration // responses are replayed for repeated idempotency keys within window
+	inFlight          sync.Map      // idempotency keys of requests in progress
+	stop              chan struct{} // stop background jobs
 }
 
 func NewServer(addr string, certFile, key string, storage game.Storage, logger *log.Logger) *webServer {
@@ -35,22 +74,33 @@ func NewServer(addr string, certFile, key string, storage game.Storage, logger *
 		Addr:       addr,
 		Log:        logger,
 		router:     router.New(),
-		debug:      true,
+		debug:      false,
 		certFile:   certFile,
 		keyFile:    key,
 		storage:    storage,
 		parserPool: &fastjson.ParserPool{},
+		stop:       make(chan struct{}),
+		rateLimits: make(map[string]*rateLimiter),
+		bans:       newBanList(),
 	}
+	// request Host header is set by client, links are generated for listen address by default
+	if addrs, err := parseListenAddrs(addr); err == nil {
+		s.listenURL = listenURL(addrs, hostname())
+	}
+	s.touchList()
 	return s
 }
 
 func (ws *webServer) Close() {
-	_ = ws.ln.Close()
+	for _, ln := range ws.listeners {
+		_ = ln.Close()
+	}
 }
 
 func (ws *webServer) Shutdown() {
 	//ws.Close() // close listener
 	ws.Log.Info("shutting down web server")
+	close(ws.stop)
 
 	err := ws.server.Shutdown()
 	if err != nil {
@@ -68,14 +118,46 @@ func (ws *webServer) Shutdown() {
 func (ws *webServer) Run() (err error) {
 	ws.registerHandlers()
 
-	// reuse port to run per-core server instance
-	ws.ln, err = reuseport.Listen("tcp4", ws.Addr)
+	if ws.maxRunningGames > 0 {
+		if err = ws.running.load(ws.storage); err != nil {
+			ws.Log.Errorln("can't count running games:", err)
+			return err
+		}
+	}
+
+	addrs, err := parseListenAddrs(ws.Addr)
 	if err != nil {
 		return err
 	}
 
+	// certificate is needed for TLS listeners only
+	var tlsConfig *tls.Config
+	for _, a := range addrs {
+		if !a.tls {
+			continue
+		}
+		tlsConfig, err = ws.tlsConfig(addrs)
+		if err != nil {
+			ws.Log.Errorln("can't load TLS certificate:", err)
+			return err
+		}
+		break
+	}
+
+	for _, a := range addrs {
+		ln, err := a.listen()
+		if err != nil {
+			ws.Close()
+			return err
+		}
+		if a.tls {
+			ln = &tlsListener{Listener: tls.NewListener(ln, tlsConfig), conns: &ws.tlsConns}
+		}
+		ws.listeners = append(ws.listeners, ln)
+	}
+
 	ws.server = &fasthttp.Server{
-		Handler:            ws.router.Handler,
+		Handler:            ws.handler(),
 		Name:               "tic-tac-toe server",
 		ReadBufferSize:     1024,
 		MaxConnsPerIP:      1024,
@@ -84,6 +166,13 @@ func (ws *webServer) Run() (err error) {
 		Logger:             ws.Log,
 	}
 
+	if ws.certReload > 0 && ws.certs != nil {
+		go ws.certs.watch(ws.certReload, ws.stop)
+	}
+	if ws.trashRetention > 0 || ws.idempotencyWindow > 0 || ws.accounts {
+		go ws.purgeLoop()
+	}
+
 	// install shutdown handler
 	killChan := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
@@ -95,12 +184,20 @@ func (ws *webServer) Run() (err error) {
 		done <- true
 	}()
 
-	// start server
-	ws.Log.Info("starting server at ", *addr)
-	err = ws.server.ServeTLS(ws.ln, ws.certFile, ws.keyFile)
-	if err != nil {
-		ws.Log.Errorln("can't start server:", err)
-		return err
+	// start server on every listener, Serve returns when listener is closed by shutdown
+	errs := make(chan error, len(ws.listeners))
+	for idx, ln := range ws.listeners {
+		ws.Log.Info("starting server at ", addrs[idx])
+		go func(ln net.Listener) {
+			errs <- ws.server.Serve(ln)
+		}(ln)
+	}
+	for range ws.listeners {
+		if err = <-errs; err != nil {
+			ws.Log.Errorln("can't start server:", err)
+			ws.Close()
+			return err
+		}
 	}
 
 	// wait while service is shutting down
@@ -110,20 +207,138 @@ func (ws *webServer) Run() (err error) {
 	return nil
 }
 
+// purge deleted games after retention period, expired idempotent responses and sessions
+func (ws *webServer) purgeLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if ws.trashRetention > 0 {
+			n, err := ws.storage.PurgeDeleted(time.Now().Add(-ws.trashRetention))
+			if err != nil {
+				ws.Log.Errorln("can't purge deleted games:", err)
+			} else if n > 0 {
+				ws.Log.Infof("purged %d deleted games", n)
+			}
+		}
+		if ws.idempotencyWindow > 0 {
+			n, err := ws.storage.PurgeIdempotentResponses(time.Now().Add(-ws.idempotencyWindow))
+			if err != nil {
+				ws.Log.Errorln("can't purge idempotent responses:", err)
+			} else if n > 0 {
+				ws.Log.Infof("purged %d idempotent responses", n)
+			}
+		}
+		if ws.accounts {
+			n, err := ws.storage.PurgeSessions(time.Now())
+			if err != nil {
+				ws.Log.Errorln("can't purge expired sessions:", err)
+			} else if n > 0 {
+				ws.Log.Infof("purged %d expired sessions", n)
+			}
+		}
+
+		select {
+		case <-ws.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// logger of request handler f with request id, client address, scheme and identity subject of
+// authenticated client
+func (ws *webServer) requestLog(ctx *fasthttp.RequestCtx, f string) *log.Entry {
+	fields := log.Fields{
+		"req":    strconv.FormatUint(ctx.ID(), 26),
+		"f":      f,
+		"ip":     clientIP(ctx),
+		"scheme": requestScheme(ctx),
+	}
+	if id := identity(ctx); id != nil {
+		fields["sub"] = id.Subject
+	}
+	return ws.Log.WithFields(fields)
+}
+
+// base URL of the service for generated links. Client Host header is never used, so replayed links
+// don't point to hosts of other clients. Without public URL, scheme and host forwarded by trusted
+// proxy are used
+func (ws *webServer) baseURL(ctx *fasthttp.RequestCtx) string {
+	if ws.publicURL != "" {
+		return ws.publicURL
+	}
+	if host, ok := ctx.UserValue(hostKey).(string); ok {
+		return requestScheme(ctx) + "://" + host
+	}
+	return ws.listenURL
+}
+
 func (ws *webServer) registerHandlers() {
-	ws.router.GET("/api/v1/games", ws.Recovery(ws.getAllGames))
-	ws.router.POST("/api/v1/games", ws.Recovery(ws.startNewGame))
-	ws.router.GET("/api/v1/games/{game_id}", ws.Recovery(ws.getGame))
-	ws.router.PUT("/api/v1/games/{game_id}", ws.Recovery(ws.makeMove))
-	ws.router.DELETE("/api/v1/games/{game_id}", ws.Recovery(ws.deleteGame))
+	ws.router.NotFound = func(ctx *fasthttp.RequestCtx) {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusNotFound, "resource not found"))
+	}
+	ws.router.MethodNotAllowed = func(ctx *fasthttp.RequestCtx) {
+		setProblem(ctx, game.NewGameError(fasthttp.StatusMethodNotAllowed, "method not allowed"))
+	}
+
+	ws.router.GET("/api/v1/games", ws.Recovery(ws.Authenticate(ws.RateLimit(rateList, ws.getAllGames))))
+	ws.router.POST("/api/v1/games", ws.Recovery(ws.Authenticate(ws.RateLimit(rateCreate, ws.Idempotent(ws.startNewGame)))))
+	ws.router.GET("/api/v1/games/{game_id}", ws.Recovery(ws.Authenticate(ws.getGame)))
+	ws.router.PUT("/api/v1/games/{game_id}", ws.Recovery(ws.Authenticate(ws.RateLimit(rateMove, ws.Idempotent(ws.makeMove)))))
+	ws.router.POST("/api/v1/games/{game_id}/moves", ws.Recovery(ws.Authenticate(ws.RateLimit(rateMove, ws.Idempotent(ws.makeMove)))))
+	ws.router.DELETE("/api/v1/games/{game_id}", ws.Recovery(ws.RequireRole(roleAdmin, ws.deleteGame)))
+	ws.router.POST("/api/v1/games:batchDelete", ws.Recovery(ws.RequireRole(roleAdmin, ws.batchDelete)))
+	ws.router.POST("/api/v1/games:batch", ws.Recovery(ws.RequireRole(roleAdmin, ws.batchGames)))
+	ws.router.GET("/api/v2/games", ws.Recovery(ws.Authenticate(ws.RateLimit(rateList, ws.getAllGamesV2))))
+	ws.router.POST("/api/v2/games", ws.Recovery(ws.Authenticate(ws.RateLimit(rateCreate, ws.Idempotent(ws.startNewGameV2)))))
+	ws.router.GET("/api/v2/games/{game_id}", ws.Recovery(ws.Authenticate(ws.getGameV2)))
+	ws.router.POST("/api/v2/games/{game_id}/moves", ws.Recovery(ws.Authenticate(ws.RateLimit(rateMove, ws.Idempotent(ws.makeMoveV2)))))
+	ws.router.DELETE("/api/v2/games/{game_id}", ws.Recovery(ws.RequireRole(roleAdmin, ws.deleteGame)))
+	ws.router.GET("/api/v1/openapi.json", ws.Recovery(ws.getOpenAPI))
+	ws.router.GET("/api/docs", ws.Recovery(ws.getAPIExplorer))
+	ws.router.GET("/api/v1/trash", ws.Recovery(ws.Authenticate(ws.RateLimit(rateList, ws.getDeletedGames))))
+	ws.router.POST("/api/v1/trash/{game_id}/restore", ws.Recovery(ws.Authenticate(ws.restoreGame)))
+	ws.router.GET("/api/v1/archive", ws.Recovery(ws.Authenticate(ws.RateLimit(rateList, ws.getArchivedGames))))
+
+	if ws.accounts {
+		ws.router.POST("/api/v2/users", ws.Recovery(ws.RateLimit(rateLogin, ws.register)))
+		ws.router.GET("/api/v2/users/me", ws.Recovery(ws.Authenticate(ws.RateLimit(rateList, ws.getProfile))))
+		ws.router.GET("/api/v2/users/me/games", ws.Recovery(ws.Authenticate(ws.RateLimit(rateList, ws.getUserGames))))
+		ws.router.POST("/api/v2/sessions", ws.Recovery(ws.RateLimit(rateLogin, ws.login)))
+		ws.router.DELETE("/api/v2/sessions/current", ws.Recovery(ws.Authenticate(ws.logout)))
+	}
+
+	// admin namespace is guarded by Authorize, moderators are allowed by default
+	if ws.adminEnabled() {
+		ws.router.GET("/api/admin/stats", ws.Recovery(ws.RequireRole(roleAdmin, ws.getStorageStats)))
+		ws.router.GET("/api/admin/games", ws.Recovery(ws.RequireRole(roleAdmin, ws.getAdminGames)))
+		ws.router.DELETE("/api/admin/games/{game_id}", ws.Recovery(ws.RequireRole(roleAdmin, ws.adminDeleteGame)))
+		ws.router.POST("/api/admin/games/{game_id}/finish", ws.Recovery(ws.finishGame))
+		ws.router.POST("/api/admin/games/{game_id}/owner", ws.Recovery(ws.RequireRole(roleAdmin, ws.assignOwner)))
+		ws.router.GET("/api/admin/trash", ws.Recovery(ws.RequireRole(roleAdmin, ws.getAdminTrash)))
+		ws.router.POST("/api/admin/trash/{game_id}/restore", ws.Recovery(ws.RequireRole(roleAdmin, ws.adminRestoreGame)))
+		ws.router.GET("/api/admin/bans", ws.Recovery(ws.getBans))
+		ws.router.POST("/api/admin/bans", ws.Recovery(ws.addBan))
+		ws.router.DELETE("/api/admin/bans/{client}", ws.Recovery(ws.removeBan))
+		ws.router.GET("/api/admin/errors", ws.Recovery(ws.getRecentErrors))
+		ws.router.GET("/api/admin/snapshot", ws.Recovery(ws.RequireRole(roleAdmin, ws.getSnapshot)))
+		ws.router.GET("/api/admin/migration", ws.Recovery(ws.RequireRole(roleAdmin, ws.getMigrationReport)))
+	}
+}
+
+// router wrapped by middlewares which are applied to every request
+func (ws *webServer) handler() fasthttp.RequestHandler {
+	return ws.Proxy(ws.CORS(ws.Compress(ws.Negotiate(ws.Authorize(ws.Validate(ws.router.Handler))))))
 }
 
 func (ws *webServer) Recovery(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
 	fn := func(ctx *fasthttp.RequestCtx) {
 		defer func() {
 			if rvr := recover(); rvr != nil {
-				ws.Log.Errorln("recover:", rvr)
-				ctx.Error("recover", fasthttp.StatusInternalServerError)
+				ws.requestLog(ctx, "Recovery").Errorln("recover:", rvr)
+				ctx.ResetBody()
+				setProblem(ctx, game.NewGameError(fasthttp.StatusInternalServerError, "recover"))
 			}
 		}()
 
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tic-tac-toe/apispec"
+	"tic-tac-toe/game"
+)
+
+const (
+	testAliceKey = "jDlZ9AV5qwNxDqgXVz6AXzZtafh2muLWpqiwa-TsQk8"
+	testBobKey   = "ByrEFbgtSf_OglIFBt0ivvao1okAbwtBz3T3qCxhiy8"
+)
+
+func testLogger() *log.Logger {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	return logger
+}
+
+// server with empty storage in temp dir, configure is called before handlers are registered
+func newTestServer(t *testing.T, configure func(ws *webServer)) *webServer {
+	dir, err := ioutil.TempDir("", "tic-tac-toe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	// restore moves storage dir away next to it
+	path := filepath.Join(dir, "storage")
+	if err = os.Mkdir(path, 0750); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := game.NewStorage(path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := NewServer("127.0.0.1:0", "", "", storage, testLogger())
+	if ws.spec, err = apispec.Load(apiSpecYAML); err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configure(ws)
+	}
+	ws.registerHandlers()
+	return ws
+}
+
+// API keys of alice and bob
+func testAPIKeys() apiKeys {
+	keys := apiKeys{}
+	for name, key := range map[string]string{"alice": testAliceKey, "bob": testBobKey} {
+		sum := sha256.Sum256([]byte(key))
+		keys[string(sum[:])] = name
+	}
+	return keys
+}
+
+// run request through all middlewares, headers are name and value pairs
+func doRequest(ws *webServer, method, uri, body string, headers ...string) *fasthttp.Response {
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	req.Header.SetHost("localhost")
+	if body != "" {
+		req.Header.SetContentType(applicationJson)
+		req.SetBodyString(body)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}, testLogger())
+	ws.handler()(&ctx)
+
+	// read streamed body
+	ctx.Response.SetBody(ctx.Response.Body())
+	return &ctx.Response
+}
+
+func TestRequestLog(t *testing.T) {
+	ws := newTestServer(t, func(ws *webServer) {
+		ws.authenticators = []authenticator{testAPIKeys().authenticate}
+	})
+	var buf bytes.Buffer
+	ws.Log.SetOutput(&buf)
+	ws.Log.SetFormatter(&log.JSONFormatter{})
+
+	// info lines of handlers carry client address, scheme and subject
+	doRequest(ws, "GET", "/api/v1/games/00000000-0000-0000-0000-000000000000", "", apiKeyHeader, testAliceKey)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if fastjson.GetString([]byte(line), "level") != "info" || fastjson.GetString([]byte(line), "f") != "getGame" {
+			continue
+		}
+		for field, value := range map[string]string{"sub": "key:alice", "ip": "127.0.0.1", "scheme": "http"} {
+			if v := fastjson.GetString([]byte(line), field); v != value {
+				t.Errorf("got %s %q, expected %q", field, v, value)
+			}
+		}
+		return
+	}
+	t.Fatalf("handler info line isn't logged: %s", buf.String())
+}
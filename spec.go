@@ -0,0 +1,64 @@
+package main
+
+import (
+	_ "embed"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"tic-tac-toe/apispec"
+	"tic-tac-toe/game"
+)
+
+//go:embed misc/api.yml
+var apiSpecYAML []byte
+
+//go:embed misc/explorer.html
+var explorerHTML []byte
+
+// serve API spec converted to OpenAPI 3
+func (ws *webServer) getOpenAPI(ctx *fasthttp.RequestCtx) {
+	setOkResponse(ctx, ws.openAPI)
+}
+
+// serve interactive API explorer, it works with OpenAPI document of this instance
+func (ws *webServer) getAPIExplorer(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(explorerHTML)
+}
+
+// validate requests against API spec. In debug mode responses are validated too, mismatches are logged
+func (ws *webServer) Validate(next func(ctx *fasthttp.RequestCtx)) func(ctx *fasthttp.RequestCtx) {
+	fn := func(ctx *fasthttp.RequestCtx) {
+		method, path := string(ctx.Method()), string(ctx.Path())
+
+		err := ws.spec.ValidateRequest(&apispec.Request{
+			Method: method,
+			Path:   path,
+			Body:   ctx.Request.Body(),
+			Query: func(name string) (string, bool) {
+				v := ctx.QueryArgs().Peek(name)
+				return string(v), v != nil
+			},
+			Header: func(name string) (string, bool) {
+				v := ctx.Request.Header.Peek(name)
+				return string(v), v != nil
+			},
+		})
+		if err != nil {
+			ws.requestLog(ctx, "Validate").Debugln("invalid request:", method, path, err)
+			setProblem(ctx, game.NewGameError(fasthttp.StatusBadRequest, err.Error()).WithCode(game.CodeInvalidRequest))
+			return
+		}
+
+		// do next
+		next(ctx)
+
+		if !ws.debug || ctx.Response.IsBodyStream() || !strings.Contains(string(ctx.Response.Header.ContentType()), "json") {
+			return
+		}
+		if err = ws.spec.ValidateResponse(method, path, ctx.Response.StatusCode(), ctx.Response.Body()); err != nil {
+			ws.requestLog(ctx, "Validate").Warnln("response doesn't match API spec:", method, path, ctx.Response.StatusCode(), err)
+		}
+	}
+	return fn
+}
@@ -4,11 +4,12 @@ export OPENSSL_CONF=./openssl.conf
 
 host="localhost"
 ipaddr="127.0.0.1"
+name=""
 
 script_dir="${0%%/*}"
 
 usage() {
-	echo "usage: ${0##*/} ca | service -h <host> -a <ip_addr> | ca-show | service-show"
+	echo "usage: ${0##*/} ca | service -h <host> -a <ip_addr> | client -n <name> | ca-show | service-show"
 	echo ""
 	echo "Commands:"
 	echo "  ca - generate CA cert"
@@ -17,6 +18,9 @@ usage() {
 	echo "      host        alternative dns name for cert (default: localhost)"
 	echo "      ipaddr      alternative ip for cert (default: 127.0.0.1)"
 	echo ""
+	echo "  client - generate client cert signed by CA, files are client-<name>.key and client-<name>.crt"
+	echo "      name        player name, it is the common name of cert"
+	echo ""
 	echo "  ca-show - show CA cert"
 	echo ""
 	echo "  service-show - show service cert"
@@ -56,6 +60,24 @@ case $1 in
 			-extfile <(cat ./v3.ext <(printf "subjectAltName = DNS:${host},IP:${ipaddr}\n"))
 		;;
 
+	client)
+		shift
+		while getopts "n:" opt; do
+			case $opt in
+				n) name=$OPTARG ;;
+				*) usage ;;
+			esac
+		done
+		[ -n "${name}" ] || usage
+
+		openssl req -new -nodes -out client-${name}.csr -newkey rsa:4096 -keyout client-${name}.key \
+			-subj "/C=FI/O=tic-tac-toe corp./OU=players/CN=${name}"
+		openssl x509 -req -in client-${name}.csr \
+			-CA rootCA.crt -CAkey rootCA.key -CAcreateserial \
+			-out client-${name}.crt -days 30 -sha512 \
+			-extfile <(cat ./v3.ext <(printf "extendedKeyUsage = clientAuth\n"))
+		;;
+
 	clean)
 		rm -f *.crt *.csr *.srl *.pem *.key
 		;;